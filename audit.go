@@ -0,0 +1,266 @@
+package hindsight
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuditStorage is what the privacy audit needs from the storage.
+type AuditStorage interface {
+	Storage
+	// Paths lists the distinct paths stored, with the number of events for each.
+	Paths() (map[string]int64, error)
+	// ReplacePath rewrites the path of all events with path `from` to `to`.
+	ReplacePath(from, to string) (int64, error)
+	// CountEventsBefore counts the events stored from before t.
+	CountEventsBefore(t time.Time) (int64, error)
+	// DeleteEventsBefore removes the events stored from before t.
+	DeleteEventsBefore(t time.Time) (int64, error)
+	// SaltDays lists the days we have salts stored for.
+	SaltDays() ([]int64, error)
+	DeleteSaltsBefore(day int64) error
+}
+
+// the kinds of problem the audit finds
+const (
+	AuditEmail     = "email"      // an email address in the path
+	AuditToken     = "token"      // something like a secret token in the path
+	AuditNumericID = "numeric-id" // a long number in the path, probably identifying something
+	AuditQueryKey  = "query-key"  // a query parameter that usually holds personal data
+	AuditRare      = "rare"       // a combination of dimensions few visitors share
+	AuditRetention = "retention"  // events kept longer than the retention period
+	AuditSalt      = "salt"       // a salt kept longer than it is needed
+)
+
+type AuditFinding struct {
+	Kind    string
+	Detail  string // e.g. the path, or the combination of dimensions
+	Events  int64  // the number of events affected
+	Redacts string `json:",omitempty"` // what redaction would change it to, if possible
+}
+
+type AuditReport struct {
+	Findings []*AuditFinding
+	Redacted int64 // events changed or deleted by redaction
+}
+
+var (
+	auditEmailRe   = regexp.MustCompile(`[A-Za-z0-9._%+-]+(@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	auditJWTRe     = regexp.MustCompile(`eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]*`)
+	auditHexRe     = regexp.MustCompile(`\b[0-9a-fA-F]{32,}\b`)
+	auditBase64Re  = regexp.MustCompile(`[A-Za-z0-9_-]{24,}`)
+	auditNumericRe = regexp.MustCompile(`\b[0-9]{6,}\b`)
+)
+
+// query parameters that usually hold personal data or secrets
+var auditQueryKeys = []string{
+	"email", "mail", "e-mail", "token", "access_token", "id_token", "refresh_token", "key",
+	"apikey", "api_key", "auth", "authorization", "session", "sessionid", "session_id", "sid",
+	"password", "passwd", "pass", "pwd", "secret", "code", "signature", "sig",
+	"user", "username", "userid", "user_id", "uid", "name", "phone", "tel", "address",
+}
+
+// a long run of letters and digits is probably a token, if it mixes
+// upper and lower case with digits, rather than being a long slug.
+func looksLikeToken(s string) bool {
+	var upper, lower, digit bool
+	for _, r := range s {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= '0' && r <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// RedactPath finds personal data in the path, returning the kinds found
+// and the path with them replaced.
+func RedactPath(p string) (string, []string) {
+	var kinds []string
+	found := func(kind string) {
+		for _, k := range kinds {
+			if k == kind {
+				return
+			}
+		}
+		kinds = append(kinds, kind)
+	}
+	redacted := p
+	query := ""
+	if i := strings.IndexByte(redacted, '?'); i != -1 {
+		redacted, query = redacted[:i], redacted[i+1:]
+	}
+	redactPart := func(s string) string {
+		s = auditEmailRe.ReplaceAllStringFunc(s, func(string) string {
+			found(AuditEmail)
+			return "[email]"
+		})
+		s = auditJWTRe.ReplaceAllStringFunc(s, func(string) string {
+			found(AuditToken)
+			return "[token]"
+		})
+		s = auditHexRe.ReplaceAllStringFunc(s, func(string) string {
+			found(AuditToken)
+			return "[token]"
+		})
+		s = auditBase64Re.ReplaceAllStringFunc(s, func(m string) string {
+			if !looksLikeToken(m) {
+				return m
+			}
+			found(AuditToken)
+			return "[token]"
+		})
+		s = auditNumericRe.ReplaceAllStringFunc(s, func(string) string {
+			found(AuditNumericID)
+			return "[id]"
+		})
+		return s
+	}
+	redacted = redactPart(redacted)
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			// we can't tell what is in it, so get rid of it
+			found(AuditQueryKey)
+			return redacted, kinds
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(values))
+		for _, k := range keys {
+			for _, v := range values[k] {
+				if containsString(auditQueryKeys, strings.ToLower(k)) {
+					found(AuditQueryKey)
+					v = "[redacted]"
+				} else {
+					v = auditPlaceholders.Replace(url.QueryEscape(redactPart(v)))
+				}
+				parts = append(parts, url.QueryEscape(k)+"="+v)
+			}
+		}
+		redacted += "?" + strings.Join(parts, "&")
+	}
+	if len(kinds) == 0 {
+		return p, nil
+	}
+	return redacted, kinds
+}
+
+// the placeholders are left readable when the rest of a query value is escaped.
+var auditPlaceholders = strings.NewReplacer(
+	url.QueryEscape("[email]"), "[email]",
+	url.QueryEscape("[token]"), "[token]",
+	url.QueryEscape("[id]"), "[id]",
+)
+
+// the combination of dimensions we look for rare values of
+var auditRareDimensions = []*Dimension{DimHost, DimCountry, DimDevice, DimBrowser, DimOS}
+
+// Audit inspects the stored data for potential personal data. If redact is
+// true, paths are rewritten and expired events and salts deleted. Rare combinations of
+// dimensions are only reported, as redacting them would ruin the data.
+func Audit(c *Config, store AuditStorage, now time.Time, redact bool) (*AuditReport, error) {
+	report := &AuditReport{}
+
+	paths, err := store.Paths()
+	if err != nil {
+		return nil, err
+	}
+	for p, count := range paths {
+		redacted, kinds := RedactPath(p)
+		for _, kind := range kinds {
+			report.Findings = append(report.Findings, &AuditFinding{Kind: kind, Detail: p, Events: count, Redacts: redacted})
+		}
+		if redact && len(kinds) > 0 {
+			n, err := store.ReplacePath(p, redacted)
+			if err != nil {
+				return report, err
+			}
+			report.Redacted += n
+		}
+	}
+
+	// rare combinations, over all the data we have
+	threshold := c.Reports.MinVisitors
+	if threshold < 2 {
+		threshold = 2
+	}
+	events, err := store.Fetch(time.Unix(0, 0), now, &Filter{Bots: BotsExclude})
+	if err != nil {
+		return report, err
+	}
+	for _, row := range aggregate(events, auditRareDimensions) {
+		// anonymous events cannot be linked, so 0 visitors is fine
+		if row.Visitors > 0 && row.Visitors < int64(threshold) {
+			report.Findings = append(report.Findings, &AuditFinding{
+				Kind:   AuditRare,
+				Detail: fmt.Sprintf("%d visitor(s): %s", row.Visitors, strings.Join(row.Values, " / ")),
+				Events: row.Hits,
+			})
+		}
+	}
+
+	if c.Privacy.RetentionDays > 0 {
+		cutoff := now.AddDate(0, 0, -c.Privacy.RetentionDays)
+		n, err := store.CountEventsBefore(cutoff)
+		if err != nil {
+			return report, err
+		}
+		if n > 0 {
+			report.Findings = append(report.Findings, &AuditFinding{
+				Kind:   AuditRetention,
+				Detail: fmt.Sprintf("events from before %s", cutoff.Format("2006-01-02")),
+				Events: n,
+			})
+			if redact {
+				n, err := store.DeleteEventsBefore(cutoff)
+				if err != nil {
+					return report, err
+				}
+				report.Redacted += n
+			}
+		}
+	}
+
+	// salts should be gone once their period (and grace) is over.
+	first, _ := NewSalts(nil, c.Privacy.SaltGraceDuration(), c.Identity.Rotation).window(now)
+	days, err := store.SaltDays()
+	if err != nil {
+		return report, err
+	}
+	stale := false
+	for _, day := range days {
+		if day < first {
+			stale = true
+			report.Findings = append(report.Findings, &AuditFinding{
+				Kind:   AuditSalt,
+				Detail: fmt.Sprintf("salt for %s still stored", time.Unix(day*86400, 0).UTC().Format("2006-01-02")),
+			})
+		}
+	}
+	if redact && stale {
+		if err := store.DeleteSaltsBefore(first); err != nil {
+			return report, err
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Detail < b.Detail
+	})
+	return report, nil
+}
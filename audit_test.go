@@ -0,0 +1,68 @@
+package hindsight
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactPath(t *testing.T) {
+	cases := []struct {
+		in, out string
+		kinds   []string
+	}{
+		{"/blog/a-long-but-harmless-post-title", "/blog/a-long-but-harmless-post-title", nil},
+		{"/users/bob@example.com/profile", "/users/[email]/profile", []string{AuditEmail}},
+		{"/orders/12345678", "/orders/[id]", []string{AuditNumericID}},
+		{"/2021/10/post", "/2021/10/post", nil},
+		{"/reset/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", "/reset/[token]", []string{AuditToken}},
+		{"/confirm/aB3dE5gH7jK9mN1pQ3sT5vX7", "/confirm/[token]", []string{AuditToken}},
+		{"/search?q=shoes&email=bob%40example.com", "/search?email=[redacted]&q=shoes", []string{AuditQueryKey}},
+		{"/?page=2", "/?page=2", nil},
+		{"/search?q=a%26b%3Dc&token=x", "/search?q=a%26b%3Dc&token=[redacted]", []string{AuditQueryKey}},
+		{"/search?q=bob%40example.com+%26+co", "/search?q=[email]+%26+co", []string{AuditEmail}},
+	}
+	for _, c := range cases {
+		out, kinds := RedactPath(c.in)
+		if out != c.out {
+			t.Errorf("RedactPath(%q): expected %q, got %q", c.in, c.out, out)
+		}
+		if strings.Join(kinds, ",") != strings.Join(c.kinds, ",") {
+			t.Errorf("RedactPath(%q): expected kinds %v, got %v", c.in, c.kinds, kinds)
+		}
+	}
+}
+
+func TestRedactSecureDelete(t *testing.T) {
+	path := t.TempDir() + "/events.db"
+	store, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+	old := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = store.Store(
+		&Event{Time: old, Host: "example.com", Path: "/old/nobody-should-find-this-one", Method: "GET"},
+		&Event{Time: old.AddDate(1, 0, 0), Host: "example.com", Path: "/users/nobody-should-find@example.com", Method: "GET"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.ReplacePath("/users/nobody-should-find@example.com", "/users/[email]"); err != nil || n != 1 {
+		t.Fatalf("expected 1 path replaced, got %d (%v)", n, err)
+	}
+	if n, err := store.DeleteEventsBefore(old.Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected 1 event deleted, got %d (%v)", n, err)
+	}
+	for _, name := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("nobody-should-find")) {
+			t.Errorf("expected the old paths to be overwritten in %s", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package hindsight
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BotPolicy is what we do with events from bots and crawlers.
+type BotPolicy string
+
+const (
+	BotPolicyFlag     BotPolicy = "flag"     // store with the other events, as device "bot"
+	BotPolicySeparate BotPolicy = "separate" // store in a separate table
+	BotPolicyDrop     BotPolicy = "drop"     // do not store at all
+)
+
+type BotConfig struct {
+	Policy   BotPolicy `toml:"policy"`
+	Patterns []string  `toml:"patterns"` // extra user-agent substrings that indicate a bot
+}
+
+func (bc *BotConfig) init() error {
+	switch bc.Policy {
+	case "":
+		bc.Policy = BotPolicyFlag
+	case BotPolicyFlag, BotPolicySeparate, BotPolicyDrop:
+	default:
+		return fmt.Errorf("unknown bot policy %q", bc.Policy)
+	}
+	for i := range bc.Patterns {
+		bc.Patterns[i] = strings.ToLower(bc.Patterns[i])
+	}
+	return nil
+}
+
+// user-agent substrings (lowercase) of crawlers, scripts and headless browsers
+// that the user-agent parser does not flag as bots. "bot" is only matched as
+// the end of a product name, as real devices have it in theirs, e.g. "Cubot".
+var defaultBotPatterns = []string{
+	"bot/", "bot;", "bot-", "+http", "crawl", "spider", "slurp", "scrapy", "archiver", "facebookexternalhit",
+	"feedfetcher", "mediapartners", "semrush", "ahrefs", "bytespider", "petalsearch",
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "go-http-client",
+	"java/", "libwww", "httpclient", "okhttp", "axios", "node-fetch", "guzzlehttp",
+	"headlesschrome", "phantomjs", "puppeteer", "playwright", "selenium", "lighthouse",
+	"pingdom", "uptimerobot", "statuscake", "site24x7", "newrelicpinger",
+	"better uptime", "freshping", "hetrixtools", "uptime-kuma", "datadogsynthetics",
+}
+
+// how long we remember that a visitor asked for robots.txt. a crawler fetches
+// its pages soon after, and a visitor key can be shared by everyone behind a
+// NAT (or by truncation), so this is kept short.
+const robotsMemory = time.Hour
+
+// BotDetector extends the user-agent parser's bot detection with known
+// crawler patterns, and by remembering the user-agents that fetch robots.txt
+// from each visitor.
+type BotDetector struct {
+	patterns []string
+
+	mu     sync.Mutex
+	robots map[string]time.Time // visitor key and user-agent -> when they asked for robots.txt
+	pruned time.Time
+}
+
+func NewBotDetector(bc *BotConfig) *BotDetector {
+	return &BotDetector{
+		patterns: append(append([]string{}, defaultBotPatterns...), bc.Patterns...),
+		robots:   map[string]time.Time{},
+	}
+}
+
+// IsBot decides whether an event came from a bot. The event should already
+// have the visitor key and the device from the user-agent.
+func (bd *BotDetector) IsBot(in *InboundEvent, ev *Event) bool {
+	// only the same user-agent, so a crawler does not flag the people
+	// sharing its visitor key.
+	key := ev.Key
+	if key != "" {
+		key += "\x00" + in.UserAgent
+	}
+	isRobots := strings.HasPrefix(in.Path, "/robots.txt")
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	bd.prune(in.Time)
+	if isRobots {
+		if key != "" {
+			bd.robots[key] = in.Time
+		}
+		return true
+	}
+	// anonymous events have no key, so we cannot remember them
+	if _, ok := bd.robots[key]; ok && key != "" {
+		return true
+	}
+	if ev.Device == string(DeviceBot) {
+		return true
+	}
+	return bd.matchesPattern(in.UserAgent)
+}
+
+func (bd *BotDetector) matchesPattern(userAgent string) bool {
+	if strings.TrimSpace(userAgent) == "" {
+		// real browsers always send one.
+		return true
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, p := range bd.patterns {
+		if strings.Contains(userAgent, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// forget the robots.txt visitors we no longer need, at most once an hour.
+// must be called with the lock held.
+func (bd *BotDetector) prune(now time.Time) {
+	if now.Sub(bd.pruned) < time.Hour {
+		return
+	}
+	bd.pruned = now
+	for key, t := range bd.robots {
+		if now.Sub(t) > robotsMemory {
+			delete(bd.robots, key)
+		}
+	}
+}
+
+// a name for a bot the user-agent parser didn't recognise, which
+// is usually the first product token, e.g. "curl" in "curl/7.68.0".
+func botName(userAgent string) string {
+	name := strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(name, "/ ;("); i != -1 {
+		name = name[:i]
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,96 @@
+package hindsight
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	firefoxUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/115.0"
+	chromeUserAgent  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36"
+)
+
+func TestBotDetector(t *testing.T) {
+	bc := &BotConfig{Patterns: []string{"MyMonitor"}}
+	if err := bc.init(); err != nil {
+		t.Fatal(err)
+	}
+	bd := NewBotDetector(bc)
+	now := time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name      string
+		at        time.Time
+		key, path string
+		userAgent string
+		device    string
+		bot       bool
+	}{
+		{"browser", now, "a", "/", firefoxUserAgent, "", false},
+		{"crawler", now, "b", "/", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "", true},
+		{"script", now, "c", "/", "curl/7.68.0", "", true},
+		{"headless", now, "d", "/", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/114.0.0.0 Safari/537.36", "", true},
+		{"no user-agent", now, "e", "/", " ", "", true},
+		{"custom pattern", now, "f", "/", "mymonitor/1.0", "", true},
+		{"parser says bot", now, "g", "/", firefoxUserAgent, string(DeviceBot), true},
+		{"robots.txt", now, "h", "/robots.txt", firefoxUserAgent, "", true},
+		{"after robots.txt", now.Add(time.Minute), "h", "/", firefoxUserAgent, "", true},
+		{"other user-agent after robots.txt", now.Add(time.Minute), "h", "/", chromeUserAgent, "", false},
+		{"anonymous robots.txt", now, "", "/robots.txt", firefoxUserAgent, "", true},
+		{"anonymous after robots.txt", now, "", "/", firefoxUserAgent, "", false},
+		{"robots.txt forgotten", now.Add(robotsMemory + 2*time.Hour), "h", "/", firefoxUserAgent, "", false},
+		{"cubot phone", now, "i", "/", "Mozilla/5.0 (Linux; Android 10; CUBOT_X30 Build/QP1A.190711.020) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Mobile Safari/537.36", "", false},
+		{"monitor in the name", now, "j", "/", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 ASUSMonitor", "", false},
+		{"named bot", now, "k", "/", "Mozilla/5.0 (compatible; bingbot/2.0)", "", true},
+		{"named monitor", now, "l", "/", "Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)", "", true},
+	}
+	for _, c := range cases {
+		in := &InboundEvent{Time: c.at, Path: c.path, UserAgent: c.userAgent}
+		ev := &Event{Key: c.key, Device: c.device}
+		if bot := bd.IsBot(in, ev); bot != c.bot {
+			t.Errorf("%s: expected bot %v, got %v", c.name, c.bot, bot)
+		}
+	}
+}
+
+func TestBotName(t *testing.T) {
+	cases := map[string]string{
+		"curl/7.68.0":                  "curl",
+		"python-requests/2.28.1":       "python-requests",
+		"Go-http-client/1.1":           "Go-http-client",
+		"SomeCrawler (+https://x.org)": "SomeCrawler",
+		"":                             "unknown",
+	}
+	for ua, expected := range cases {
+		if name := botName(ua); name != expected {
+			t.Errorf("botName(%q): expected %q, got %q", ua, expected, name)
+		}
+	}
+}
+
+func TestReportBotFilter(t *testing.T) {
+	crawlers, pages := LookupReport("crawlers"), LookupReport("pages")
+	cases := []struct {
+		report   *Report
+		bots     string
+		expected BotFilter
+	}{
+		{crawlers, "", BotsOnly},
+		{crawlers, "exclude", BotsExclude},
+		{crawlers, "include", BotsInclude},
+		{pages, "", BotsExclude},
+		{pages, "only", BotsOnly},
+	}
+	for _, c := range cases {
+		bots, err := ParseBotFilter(c.bots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter := c.report.filter(&ReportQuery{Filter: Filter{Bots: bots}})
+		if filter.Bots != c.expected {
+			t.Errorf("%s with %q: expected %d, got %d", c.report.Name, c.bots, c.expected, filter.Bots)
+		}
+	}
+	if _, err := ParseBotFilter("some"); err == nil {
+		t.Error("expected an error for an unknown bot filter")
+	}
+}
@@ -96,6 +96,10 @@ func (he HindsightEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field
 		case "status":
 			ev.StatusCode = int(f.Integer)
 			required++
+		case "resp_headers":
+			if h, ok := f.Interface.(caddyhttp.LoggableHTTPHeader); ok {
+				ev.ContentType = http.Header(h).Get("Content-Type")
+			}
 		}
 	}
 	if required != 3 {
@@ -0,0 +1,161 @@
+package hindsight
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Class is the kind of request an event was, so we can tell real
+// pageviews from all the other requests a web server logs.
+type Class string
+
+const (
+	ClassPageview Class = "pageview"
+	ClassAsset    Class = "asset"
+	ClassAPI      Class = "api"
+	ClassFeed     Class = "feed"
+	ClassOther    Class = "other"
+)
+
+// AllClasses is every class an event can have.
+var AllClasses = []Class{ClassPageview, ClassAsset, ClassAPI, ClassFeed, ClassOther}
+
+func parseClass(s string) (Class, error) {
+	for _, c := range AllClasses {
+		if string(c) == s {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event class %q", s)
+}
+
+// A ClassifyRule assigns a class to events matching it. All the non-empty
+// criteria must match for the rule to apply, and the first rule to match wins.
+type ClassifyRule struct {
+	Class        Class    `toml:"class"`
+	Methods      []string `toml:"methods"`       // e.g. "GET", "POST"
+	Extensions   []string `toml:"extensions"`    // extension of the path, e.g. ".css"
+	PathPrefixes []string `toml:"path_prefixes"` // e.g. "/api/"
+	ContentTypes []string `toml:"content_types"` // response media type, "image/*" matches the whole type
+}
+
+type ClassifyConfig struct {
+	Rules []*ClassifyRule `toml:"rules"`
+}
+
+// these are checked after any configured rules.
+var defaultClassifyRules = []*ClassifyRule{
+	{Class: ClassAPI, PathPrefixes: []string{"/api/"}},
+	{Class: ClassAPI, ContentTypes: []string{"application/json"}},
+	{Class: ClassFeed, Extensions: []string{".rss", ".atom"}},
+	{Class: ClassFeed, ContentTypes: []string{"application/rss+xml", "application/atom+xml", "application/feed+json"}},
+	{Class: ClassAsset, Extensions: []string{
+		".css", ".js", ".mjs", ".map",
+		".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg", ".ico", ".bmp",
+		".woff", ".woff2", ".ttf", ".otf", ".eot",
+		".mp3", ".mp4", ".webm", ".ogg", ".wav",
+		".wasm", ".txt", ".webmanifest",
+	}},
+	{Class: ClassAsset, ContentTypes: []string{
+		"text/css", "text/javascript", "application/javascript", "application/wasm",
+		"image/*", "font/*", "audio/*", "video/*",
+	}},
+	{Class: ClassPageview, Methods: []string{"GET", "HEAD"}, ContentTypes: []string{"text/html", "application/xhtml+xml"}},
+	// without a content-type, only paths that look like pages.
+	{Class: ClassPageview, Methods: []string{"GET", "HEAD"}, Extensions: []string{"", ".html", ".htm"}, ContentTypes: []string{""}},
+}
+
+// check and tidy the configured rules so matching can be done directly.
+func (cc *ClassifyConfig) init() error {
+	for i, r := range cc.Rules {
+		if _, err := parseClass(string(r.Class)); err != nil {
+			return fmt.Errorf("classify rule %d: %w", i+1, err)
+		}
+		for j := range r.Methods {
+			r.Methods[j] = strings.ToUpper(r.Methods[j])
+		}
+		for j, ext := range r.Extensions {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			r.Extensions[j] = ext
+		}
+		for j := range r.ContentTypes {
+			r.ContentTypes[j] = strings.ToLower(r.ContentTypes[j])
+		}
+	}
+	return nil
+}
+
+func (r *ClassifyRule) matches(method, ext, reqPath, contentType string) bool {
+	if len(r.Methods) > 0 && !containsString(r.Methods, method) {
+		return false
+	}
+	if len(r.Extensions) > 0 && !containsString(r.Extensions, ext) {
+		return false
+	}
+	if len(r.PathPrefixes) > 0 {
+		found := false
+		for _, prefix := range r.PathPrefixes {
+			if strings.HasPrefix(reqPath, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.ContentTypes) > 0 {
+		found := false
+		for _, ct := range r.ContentTypes {
+			if ct == contentType || (strings.HasSuffix(ct, "/*") && strings.HasPrefix(contentType, ct[:len(ct)-1])) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ClassifyRequest works out the class of a request for the given host, checking
+// the rules for the host, then the global rules, then the defaults.
+func (c *Config) ClassifyRequest(host, method, reqPath, contentType string) Class {
+	if i := strings.IndexAny(reqPath, "?#"); i != -1 {
+		reqPath = reqPath[:i]
+	}
+	ext := strings.ToLower(path.Ext(reqPath))
+	// we only want the media type, not the parameters
+	if i := strings.IndexByte(contentType, ';'); i != -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	method = strings.ToUpper(method)
+
+	ruleSets := [][]*ClassifyRule{c.Classify.Rules, defaultClassifyRules}
+	if site := c.Site(host); site != nil {
+		ruleSets = append([][]*ClassifyRule{site.Classify.Rules}, ruleSets...)
+	}
+	for _, rules := range ruleSets {
+		for _, r := range rules {
+			if r.matches(method, ext, reqPath, contentType) {
+				return r.Class
+			}
+		}
+	}
+	return ClassOther
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package hindsight
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyRequest(t *testing.T) {
+	c := &Config{
+		Sites: map[string]*SiteConfig{
+			"example.com": {Classify: ClassifyConfig{Rules: []*ClassifyRule{
+				{Class: ClassFeed, Extensions: []string{"xml"}},
+			}}},
+		},
+	}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		host, method, path, contentType string
+		expected                        Class
+	}{
+		{"example.org", "GET", "/", "text/html; charset=utf-8", ClassPageview},
+		{"example.org", "GET", "/about?x=1", "", ClassPageview},
+		{"example.org", "GET", "/style.CSS", "", ClassAsset},
+		{"example.org", "GET", "/logo", "image/png", ClassAsset},
+		{"example.org", "POST", "/api/thing", "", ClassAPI},
+		{"example.org", "GET", "/index.rss", "", ClassFeed},
+		{"example.org", "POST", "/form", "text/html", ClassOther},
+		{"example.org", "GET", "/sitemap.xml", "", ClassOther},
+		{"example.org", "GET", "/report.pdf", "", ClassOther},
+		{"example.org", "GET", "/data.json", "", ClassOther},
+		{"example.org", "GET", "/about.html", "", ClassPageview},
+		{"example.org", "HEAD", "/old/page.htm", "", ClassPageview},
+		{"example.org", "GET", "/report.pdf", "text/html", ClassPageview},
+		{"example.com", "GET", "/sitemap.xml", "", ClassFeed},
+	}
+	for _, tc := range cases {
+		if actual := c.ClassifyRequest(tc.host, tc.method, tc.path, tc.contentType); actual != tc.expected {
+			t.Errorf("%s %s%s (%q): expected %q, got %q", tc.method, tc.host, tc.path, tc.contentType, tc.expected, actual)
+		}
+	}
+}
+
+func TestReclassifyPageviews(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/", "/style.css", "/style.css", "/sitemap.xml"} {
+		if err := store.Store(&Event{Time: time.Now(), Host: "example.com", Method: "GET", Path: p, Class: ClassPageview}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reqs, err := store.Pageviews()
+	if err != nil {
+		t.Fatal(err)
+	}
+	css := StoredRequest{Host: "example.com", Method: "GET", Path: "/style.css"}
+	if len(reqs) != 3 || reqs[css] != 2 {
+		t.Fatalf("expected 3 requests with 2 for the css, got %v", reqs)
+	}
+	if n, err := store.ReclassifyPageviews(css, ClassAsset); err != nil || n != 2 {
+		t.Errorf("expected 2 events reclassified, got %d %v", n, err)
+	}
+	if reqs, _ := store.Pageviews(); len(reqs) != 2 {
+		t.Errorf("expected 2 requests left as pageviews, got %v", reqs)
+	}
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"net"
@@ -14,6 +16,8 @@ import (
 const (
 	ClientVersion         = "1.0.0"
 	HindsightEventVersion = "1.0"
+	// ExcludeCookie is set by the hindsight UI for browsers that opted out.
+	ExcludeCookie = "hindsight_exclude"
 )
 
 type Client struct {
@@ -55,6 +59,13 @@ type Event struct {
 	StatusCode   int           // must be a valid code
 	BytesWritten int           // must be non-negative
 	Duration     time.Duration `json:"-"`
+	ContentType  string        `json:",omitempty"`
+	ID           string        `json:",omitempty"` // so retries can be detected
+	DNT          bool          `json:",omitempty"` // Do Not Track
+	GPC          bool          `json:",omitempty"` // Global Privacy Control
+	// only used by the server if configured as part of the visitor identity
+	AcceptLanguage string `json:",omitempty"`
+	Excluded       bool   `json:",omitempty"` // the browser opted out, see ExcludeCookie
 }
 
 func (ev *Event) MarshalJSON() ([]byte, error) {
@@ -77,9 +88,16 @@ func (ev *Event) MarshalJSON() ([]byte, error) {
 // httpsyhook.Interface methods to record stuff
 func (ev *Event) HookWriteHeader(w http.ResponseWriter, statusCode int) {
 	ev.StatusCode = statusCode
+	ev.ContentType = w.Header().Get("Content-Type")
 	w.WriteHeader(statusCode)
 }
 func (ev *Event) HookWrite(w io.Writer, p []byte) (n int, err error) {
+	if ev.ContentType == "" {
+		// there was no explicit WriteHeader call
+		if rw, ok := w.(http.ResponseWriter); ok {
+			ev.ContentType = rw.Header().Get("Content-Type")
+		}
+	}
 	n, err = w.Write(p)
 	ev.BytesWritten += n
 	return
@@ -126,10 +144,26 @@ func SetRequestValues(req *http.Request, ev *Event, trustProxy bool) {
 	ev.Method = req.Method
 	ev.Path = req.URL.RequestURI()
 	ev.UserAgent = req.Header.Get("User-Agent")
+	ev.DNT = req.Header.Get("DNT") == "1"
+	ev.GPC = req.Header.Get("Sec-GPC") == "1"
+	ev.AcceptLanguage = req.Header.Get("Accept-Language")
+	if c, err := req.Cookie(ExcludeCookie); err == nil && c.Value == "1" {
+		ev.Excluded = true
+	}
+}
+
+// random, so the same event sent twice can be recognised.
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// the server will use the content of the event instead.
+		return ""
+	}
+	return hex.EncodeToString(b)
 }
 
 func (c *Client) Wrap(rw http.ResponseWriter, req *http.Request) (http.ResponseWriter, *Event) {
-	ev := &Event{Time: time.Now()}
+	ev := &Event{Time: time.Now(), ID: newEventID()}
 	SetRequestValues(req, ev, c.trustProxy)
 	return httpsyhook.Wrap(rw, ev), ev
 }
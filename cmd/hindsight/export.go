@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/0x6377/hindsight"
+)
+
+type exportFlags struct {
+	reportFlags
+	epsilon float64
+	format  string
+	out     string
+}
+
+func exportReport(c *hindsight.Config, name string, ef *exportFlags) error {
+	r := hindsight.LookupReport(name)
+	if r == nil {
+		return fmt.Errorf("no report named %q", name)
+	}
+	q, err := ef.query(c)
+	if err != nil {
+		return err
+	}
+	epsilon := ef.epsilon
+	if epsilon == 0 {
+		epsilon = c.Export.Epsilon
+	}
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	res, err := hindsight.RunPrivateReport(c, storage, storage, r, q, epsilon)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if ef.out != "" {
+		f, err := os.Create(ef.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch ef.format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(append(append([]string{}, res.Columns...), "visitors", "hits"))
+		for _, row := range res.Rows {
+			cw.Write(append(append([]string{}, row.Values...), strconv.FormatInt(row.Visitors, 10), strconv.FormatInt(row.Hits, 10)))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown format %q, should be json or csv", ef.format)
+}
+
+func showBudget(c *hindsight.Config) error {
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	spent, err := storage.SpentBudget()
+	if err != nil {
+		return err
+	}
+	periods := make([]string, 0, len(spent))
+	for p := range spent {
+		periods = append(periods, p)
+	}
+	sort.Strings(periods)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tSPENT\tREMAINING")
+	for _, p := range periods {
+		fmt.Fprintf(tw, "%s\t%g\t%g\n", p, spent[p], c.Export.Budget-spent[p])
+	}
+	return tw.Flush()
+}
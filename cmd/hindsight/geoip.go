@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0x6377/hindsight"
+	"github.com/0x6377/hindsight/geoip"
+	"github.com/rs/zerolog/log"
+)
+
+// download the geoip city (or ASN) database to out, or the configured database.
+func geoipUpdate(c *hindsight.Config, out string, asn, force bool) error {
+	if out == "" {
+		kind := c.GeoIP.Kind
+		out = c.GeoIP.Database
+		if asn {
+			out, kind = c.GeoIP.ASNDatabase, c.GeoIP.ASNKind
+		}
+		if out != "" && kind != "maxmind" {
+			return fmt.Errorf("the configured geoip database is a %s database, give a maxmind one with --out", kind)
+		}
+	}
+	if out == "" {
+		return errors.New("no geoip database configured, give one with --out")
+	}
+	if c.GeoIP.LicenseKey == "" && c.GeoIP.DownloadURL == "" {
+		return errors.New("no license key, set geoip.license_key or MAXMIND_LICENSE_KEY")
+	}
+	installed, err := hindsight.UpdateGeoIP(context.Background(), c, out, asn, force)
+	if err != nil {
+		return err
+	}
+	if installed {
+		log.Info().Str("path", out).Msg("installed new geoip db")
+	} else {
+		log.Info().Str("path", out).Msg("geoip db already up to date")
+	}
+	return nil
+}
+
+// rewrite the database at in to out, with only the fields we use.
+func geoipStrip(in, out string, opts geoip.StripOptions) error {
+	src, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	stripped, stats, err := geoip.Strip(src, opts)
+	if err != nil {
+		return err
+	}
+	// replace it atomically, it may be in use.
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".geoip-*.mmdb")
+	if err != nil {
+		return fmt.Errorf("could not create geoip db: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(stripped)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return fmt.Errorf("could not install geoip db: %w", err)
+	}
+	log.Info().Str("path", out).
+		Int("networks", stats.Networks).
+		Int("kept", stats.Kept).
+		Int("bytes_before", len(src)).
+		Int("bytes_after", len(stripped)).
+		Msg("stripped geoip db")
+	return nil
+}
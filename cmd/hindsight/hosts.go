@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/0x6377/hindsight"
+	"github.com/rs/zerolog/log"
+)
+
+// list the stored hosts and what they would be canonicalised to.
+func listHosts(c *hindsight.Config) error {
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	hosts, err := storage.Hosts()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(hosts))
+	for h := range hosts {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tEVENTS\tCANONICAL")
+	for _, h := range names {
+		canon := c.Hosts.CanonicalHost(h)
+		if canon == h {
+			canon = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", h, hosts[h], canon)
+	}
+	return tw.Flush()
+}
+
+// events from before they were classified were all stored as pageviews, so
+// check them against the current rules. The content-type isn't stored, so
+// only those the path and method say aren't pageviews are changed.
+func reclassifyPageviews(c *hindsight.Config, dryRun bool) error {
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	reqs, err := storage.Pageviews()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for req, count := range reqs {
+		class := c.ClassifyRequest(req.Host, req.Method, req.Path, "")
+		if class == hindsight.ClassPageview {
+			continue
+		}
+		if dryRun {
+			log.Debug().Str("method", req.Method).Str("host", req.Host).Str("path", req.Path).Str("class", string(class)).Int64("events", count).Msg("would reclassify")
+			total += count
+			continue
+		}
+		n, err := storage.ReclassifyPageviews(req, class)
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+	if dryRun {
+		log.Info().Int64("events", total).Msg("would reclassify pageviews")
+	} else {
+		log.Info().Int64("events", total).Msg("reclassified pageviews")
+	}
+	return nil
+}
+
+// apply the current host canonicalisation rules to the stored events.
+func canonicaliseHosts(c *hindsight.Config, dryRun bool) error {
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	hosts, err := storage.Hosts()
+	if err != nil {
+		return err
+	}
+	for h, count := range hosts {
+		canon := c.Hosts.CanonicalHost(h)
+		if canon == h {
+			continue
+		}
+		if dryRun {
+			log.Info().Str("from", h).Str("to", canon).Int64("events", count).Msg("would rename host")
+			continue
+		}
+		n, err := storage.RenameHost(h, canon)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("from", h).Str("to", canon).Int64("events", n).Msg("renamed host")
+	}
+	return nil
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
+	// the time zones for the reports, even without them on the system
+	_ "time/tzdata"
 
 	"github.com/0x6377/hindsight"
+	"github.com/0x6377/hindsight/geoip"
 	"github.com/mattn/go-isatty"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -88,7 +92,153 @@ func main() {
 		},
 	}
 
-	rootCmd.AddCommand(run, ingest)
+	var hosts = &cobra.Command{
+		Use:   "hosts",
+		Short: "list stored virtual hosts",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := listHosts(config)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error listing hosts")
+			}
+		},
+	}
+
+	var dryRun bool
+	var canonicalise = &cobra.Command{
+		Use:   "canonicalise",
+		Short: "apply host normalisation and aliases to stored events",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := canonicaliseHosts(config, dryRun)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error canonicalising hosts")
+			}
+		},
+	}
+	canonicalise.Flags().BoolVar(&dryRun, "dry-run", false, "only show what would change")
+	hosts.AddCommand(canonicalise)
+
+	var reclassify = &cobra.Command{
+		Use:   "reclassify",
+		Short: "apply the classify rules to events stored as pageviews, e.g. from before they were classified",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := reclassifyPageviews(config, dryRun)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error reclassifying events")
+			}
+		},
+	}
+	reclassify.Flags().BoolVar(&dryRun, "dry-run", false, "only show what would change")
+
+	rf := &reportFlags{defaultFrom: func(today time.Time) time.Time { return today.AddDate(0, 0, -7) }}
+	var report = &cobra.Command{
+		Use:   "report [name]",
+		Short: "run a report, or list the reports if no name given",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				listReports(config)
+				return
+			}
+			err := runReport(config, args[0], rf)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error running report")
+			}
+		},
+	}
+	report.Flags().StringVar(&rf.from, "from", "", "start of the report (date or RFC3339, default a week ago)")
+	report.Flags().StringVar(&rf.until, "until", "", "end of the report (date or RFC3339, default today)")
+	report.Flags().StringSliceVar(&rf.hosts, "host", nil, "only include these hosts")
+	report.Flags().StringSliceVar(&rf.classes, "class", nil, "only include these event classes (default pageviews)")
+	report.Flags().StringVar(&rf.bots, "bots", "", "exclude, include or only bot traffic (default depends on report)")
+	report.Flags().IntVar(&rf.limit, "limit", 20, "maximum rows to show, 0 for all")
+	report.Flags().BoolVar(&rf.json, "json", false, "output JSON instead of a table")
+
+	ef := &exportFlags{reportFlags: reportFlags{defaultFrom: func(today time.Time) time.Time { return today.AddDate(0, -1, 0) }}}
+	var budget bool
+	var export = &cobra.Command{
+		Use:   "export [report]",
+		Short: "export a report with differentially private noise added",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			switch {
+			case budget:
+				err = showBudget(config)
+			case len(args) == 0:
+				listReports(config)
+			default:
+				err = exportReport(config, args[0], ef)
+			}
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error exporting report")
+			}
+		},
+	}
+	export.Flags().StringVar(&ef.from, "from", "", "start of the export (date or RFC3339, default a month ago)")
+	export.Flags().StringVar(&ef.until, "until", "", "end of the export (date or RFC3339, default today)")
+	export.Flags().StringSliceVar(&ef.hosts, "host", nil, "only include these hosts")
+	export.Flags().StringSliceVar(&ef.classes, "class", nil, "only include these event classes (default pageviews)")
+	export.Flags().IntVar(&ef.limit, "limit", 0, "maximum rows to export, 0 for all")
+	export.Flags().Float64Var(&ef.epsilon, "epsilon", 0, "privacy cost of this export (default from config)")
+	export.Flags().StringVar(&ef.format, "format", "json", "json or csv")
+	export.Flags().StringVar(&ef.out, "out", "", "file to write to (default stdout)")
+	export.Flags().BoolVar(&budget, "budget", false, "show the privacy budget spent so far instead")
+
+	var privacy = &cobra.Command{
+		Use:   "privacy",
+		Short: "privacy related tools",
+	}
+	var redact, auditJSON bool
+	var audit = &cobra.Command{
+		Use:   "audit",
+		Short: "check the stored data for personal data and retention problems",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := privacyAudit(config, redact, auditJSON)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error auditing data")
+			}
+		},
+	}
+	audit.Flags().BoolVar(&redact, "redact", false, "rewrite offending paths and delete expired events and salts")
+	audit.Flags().BoolVar(&auditJSON, "json", false, "output JSON instead of a table")
+	privacy.AddCommand(audit)
+
+	var geo = &cobra.Command{
+		Use:   "geoip",
+		Short: "geolocation database tools",
+	}
+	var geoOut string
+	var geoASN, geoForce bool
+	var geoUpdate = &cobra.Command{
+		Use:   "update",
+		Short: "download and install the latest geoip database",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := geoipUpdate(config, geoOut, geoASN, geoForce)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error updating geoip database")
+			}
+		},
+	}
+	geoUpdate.Flags().StringVar(&geoOut, "out", "", "where to install the database (default geoip.database or geoip.asn_database)")
+	geoUpdate.Flags().BoolVar(&geoASN, "asn", false, "update the ASN database instead of the city database")
+	geoUpdate.Flags().BoolVar(&geoForce, "force", false, "download even if the installed database is the latest")
+	var strip geoip.StripOptions
+	var geoStrip = &cobra.Command{
+		Use:   "strip <in.mmdb> <out.mmdb>",
+		Short: "write a smaller geoip database with only the fields we use",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := geoipStrip(args[0], args[1], strip)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error stripping geoip database")
+			}
+		},
+	}
+	geoStrip.Flags().BoolVar(&strip.Regions, "regions", false, "keep the regions (subdivisions)")
+	geoStrip.Flags().BoolVar(&strip.ASN, "asn", false, "keep the network numbers and organisations")
+	geo.AddCommand(geoUpdate, geoStrip)
+
+	rootCmd.AddCommand(run, ingest, hosts, reclassify, report, export, privacy, geo)
 	rootCmd.SetVersionTemplate(fmt.Sprintf("{{.Name}} v{{.Version}} (%s)\n", COMMIT))
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/0x6377/hindsight"
+	"github.com/rs/zerolog/log"
+)
+
+// audit the stored data for personal data, optionally redacting it.
+func privacyAudit(c *hindsight.Config, redact, asJSON bool) error {
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	report, err := hindsight.Audit(c, storage, time.Now(), redact)
+	if err != nil {
+		return err
+	}
+	if redact {
+		log.Info().Int64("events", report.Redacted).Msg("redacted events")
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	if len(report.Findings) == 0 {
+		fmt.Println("no problems found")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tEVENTS\tDETAIL\tREDACTED")
+	for _, f := range report.Findings {
+		redacts := f.Redacts
+		if redacts == "" {
+			redacts = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", f.Kind, f.Events, f.Detail, redacts)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/0x6377/hindsight"
+)
+
+type reportFlags struct {
+	from, until string
+	hosts       []string
+	classes     []string
+	bots        string
+	limit       int
+	json        bool
+	// the default start, given today.
+	defaultFrom func(today time.Time) time.Time
+}
+
+// plain dates are in the site owner's time zone, and the defaults are
+// computed in it too, as the config isn't loaded until the command runs.
+func (rf *reportFlags) query(c *hindsight.Config) (*hindsight.ReportQuery, error) {
+	q := &hindsight.ReportQuery{Limit: rf.limit}
+	loc := c.Reports.Location()
+	today := time.Now().In(loc)
+	from, until := rf.from, rf.until
+	if from == "" {
+		from = rf.defaultFrom(today).Format("2006-01-02")
+	}
+	if until == "" {
+		until = today.Format("2006-01-02")
+	}
+	var err error
+	if q.From, err = hindsight.ParseTimeIn(from, loc); err != nil {
+		return nil, err
+	}
+	if q.Until, err = hindsight.ParseEndTimeIn(until, loc); err != nil {
+		return nil, err
+	}
+	if q.Filter.Bots, err = hindsight.ParseBotFilter(rf.bots); err != nil {
+		return nil, err
+	}
+	q.Filter.HostList = rf.hosts
+	for _, s := range rf.classes {
+		q.Filter.Classes = append(q.Filter.Classes, hindsight.Class(s))
+	}
+	return q, nil
+}
+
+func listReports(c *hindsight.Config) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTITLE")
+	for _, r := range hindsight.Reports() {
+		if r.Available(c) {
+			fmt.Fprintf(tw, "%s\t%s\n", r.Name, r.Title)
+		}
+	}
+	tw.Flush()
+}
+
+func runReport(c *hindsight.Config, name string, rf *reportFlags) error {
+	r := hindsight.LookupReport(name)
+	if r == nil {
+		return fmt.Errorf("no report named %q", name)
+	}
+	q, err := rf.query(c)
+	if err != nil {
+		return err
+	}
+	storage, err := hindsight.NewSQLiteStorage(c.DatabasePath)
+	if err != nil {
+		return err
+	}
+	res, err := hindsight.RunReport(c, storage, r, q)
+	if err != nil {
+		return err
+	}
+	if rf.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	fmt.Printf("%s (%s) %s - %s\n\n", res.Title, joinClasses(res.Classes),
+		res.From.Format(timeFormatLocal), res.Until.Format(timeFormatLocal))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tVISITORS\tHITS\n", strings.ToUpper(strings.Join(res.Columns, "\t")))
+	for _, row := range res.Rows {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", strings.Join(row.Values, "\t"), row.Visitors, row.Hits)
+	}
+	fmt.Fprintf(tw, "TOTAL%s\t%d\t%d\n", strings.Repeat("\t", len(res.Columns)-1), res.Total.Visitors, res.Total.Hits)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if res.Suppressed > 0 {
+		fmt.Printf("\n%d rows with too few visitors are not shown separately.\n", res.Suppressed)
+	}
+	if res.Collisions >= 0.5 {
+		fmt.Printf("\nAbout %.0f visitors are not counted, as they share a (truncated) visitor key with someone else.\n", res.Collisions)
+	}
+	if len(res.Schemes) > 1 {
+		fmt.Printf("\nVisitor keys changed scheme in this period (%s), so some visitors may be counted more than once.\n", strings.Join(res.Schemes, ", "))
+	}
+	return nil
+}
+
+func joinClasses(classes []hindsight.Class) string {
+	s := make([]string, len(classes))
+	for i, c := range classes {
+		s[i] = string(c)
+	}
+	return strings.Join(s, ", ")
+}
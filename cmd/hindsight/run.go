@@ -11,7 +11,28 @@ func run(c *hindsight.Config) error {
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
+	salts := hindsight.NewSalts(storage, c.Privacy.SaltGraceDuration(), c.Identity.Rotation)
+	if err := salts.Rotate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pipeline, err := hindsight.NewPipeline(c, &hindsight.Services{
+		Salts:   salts,
+		Locator: hindsight.StartGeoIP(ctx, c),
+	})
+	if err != nil {
+		return err
+	}
+	go hindsight.RotateSalts(ctx, salts)
 
-	return hindsight.ListenForIngestion(ctx, c, storage)
+	// if either of the listeners fail, we stop both.
+	errs := make(chan error, 2)
+	go func() {
+		errs <- hindsight.ListenForIngestion(ctx, c, pipeline, storage)
+	}()
+	go func() {
+		errs <- hindsight.ListenForUI(ctx, c, storage)
+	}()
+	return <-errs
 }
@@ -1,20 +1,151 @@
 package hindsight
 
 import (
-	"bytes"
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
-	"io/ioutil"
 
 	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog/log"
 )
 
 type Config struct {
-	ListenIngestion string // host:port for API - should not be public
-	ListenUI        string // host:port for UI - could be exposed to internet
-	DatabasePath    string // path to DB
-	RandomSaltSeed  string // A random string to use to generate the daily hashes
+	ListenIngestion string                 `toml:"listen_api"`    // host:port for API - should not be public
+	ListenUI        string                 `toml:"listen_ui"`     // host:port for UI - has no auth, so should not be public
+	DatabasePath    string                 `toml:"database_path"` // path to DB
+	Hosts           HostConfig             `toml:"hosts"`         // virtual host normalisation
+	Classify        ClassifyConfig         `toml:"classify"`      // rules for event classes
+	Bots            BotConfig              `toml:"bots"`          // bot and crawler handling
+	Privacy         PrivacyConfig          `toml:"privacy"`       // privacy settings
+	Identity        IdentityConfig         `toml:"identity"`      // how visitor keys are made
+	Exclude         ExcludeConfig          `toml:"exclude"`       // our own traffic, never recorded
+	GeoIP           GeoIPConfig            `toml:"geoip"`         // geolocation database
+	Reports         ReportConfig           `toml:"reports"`       // what reports may show
+	Export          ExportConfig           `toml:"export"`        // differentially private exports
+	Sites           map[string]*SiteConfig `toml:"site"`          // per host overrides
+	SiteGroups      map[string][]string    `toml:"site_groups"`   // hosts sharing visitor keys, by group name
+
+	Processors       []string                  `toml:"processors"` // the ingestion pipeline, in order
+	ProcessorOptions map[string]toml.Primitive `toml:"processor"`  // options for each processor, by name
+
+	meta       toml.MetaData     // to decode the processor options later
+	siteGroups map[string]string // canonical host -> group name
+}
+
+// SiteConfig holds the settings that can be overridden for a single
+// (canonical) virtual host.
+type SiteConfig struct {
+	Classify   ClassifyConfig `toml:"classify"`    // checked before the global rules
+	TruncateIP *bool          `toml:"truncate_ip"` // overrides privacy.truncate_ip
+}
+
+// Site returns the overrides for the given canonical host, or nil.
+func (c *Config) Site(host string) *SiteConfig {
+	return c.Sites[host]
+}
+
+// SaltScope is what the visitor keys for a canonical host are scoped to.
+// Every host has its own salt, unless it is in a site group, when all the
+// hosts in the group share one, so visitors can be counted across them.
+func (c *Config) SaltScope(host string) string {
+	if group, ok := c.siteGroups[host]; ok {
+		return "group:" + group
+	}
+	return "host:" + host
+}
+
+// returns a function to decode the options for the named processor.
+func (c *Config) processorOptions(name string) func(v interface{}) error {
+	return func(v interface{}) error {
+		prim, ok := c.ProcessorOptions[name]
+		if !ok {
+			return nil
+		}
+		if err := c.meta.PrimitiveDecode(prim, v); err != nil {
+			return fmt.Errorf("bad options for processor %q: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// tidy up and validate the config after loading
+func (c *Config) init() error {
+	c.Hosts.init()
+	if err := c.Classify.init(); err != nil {
+		return err
+	}
+	if err := c.Bots.init(); err != nil {
+		return err
+	}
+	if err := c.Privacy.init(); err != nil {
+		return err
+	}
+	if err := c.Identity.init(); err != nil {
+		return err
+	}
+	if err := c.Exclude.init(); err != nil {
+		return err
+	}
+	if err := c.GeoIP.init(); err != nil {
+		return err
+	}
+	if err := c.Reports.init(); err != nil {
+		return err
+	}
+	if err := c.Export.init(); err != nil {
+		return err
+	}
+	sites := make(map[string]*SiteConfig, len(c.Sites))
+	for host, site := range c.Sites {
+		if err := site.Classify.init(); err != nil {
+			return fmt.Errorf("site %q: %w", host, err)
+		}
+		// so the lookup matches the canonical host.
+		sites[c.Hosts.CanonicalHost(host)] = site
+	}
+	c.Sites = sites
+	c.siteGroups = map[string]string{}
+	for name, hosts := range c.SiteGroups {
+		for _, host := range hosts {
+			host = c.Hosts.CanonicalHost(host)
+			if other, ok := c.siteGroups[host]; ok {
+				return fmt.Errorf("host %q is in site groups %q and %q", host, other, name)
+			}
+			c.siteGroups[host] = name
+		}
+	}
+	return c.checkPrivacyProcessors()
+}
+
+// a custom pipeline must still have the processors for the privacy options
+// set, or they would silently do nothing.
+func (c *Config) checkPrivacyProcessors() error {
+	if len(c.Processors) == 0 {
+		return nil
+	}
+	if c.Privacy.Signals != SignalsIgnore && !containsString(c.Processors, "signals") {
+		return fmt.Errorf("privacy.signals is %q, but the \"signals\" processor is not in processors (set it to %q if that is intended)", c.Privacy.Signals, SignalsIgnore)
+	}
+	truncate := c.Privacy.TruncateIP
+	for _, site := range c.Sites {
+		truncate = truncate || (site.TruncateIP != nil && *site.TruncateIP)
+	}
+	if truncate && !containsString(c.Processors, "truncate_ip") {
+		return fmt.Errorf("truncate_ip is set, but the \"truncate_ip\" processor is not in processors")
+	}
+	// they must run before anything that uses the ip or keeps the event.
+	first := -1
+	for i, name := range c.Processors {
+		switch name {
+		case "visitor", "geoip", "dedup":
+			if first == -1 {
+				first = i
+			}
+		case "signals", "truncate_ip":
+			if first != -1 {
+				return fmt.Errorf("the %q processor must come before %q in processors", name, c.Processors[first])
+			}
+		}
+	}
+	return nil
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -23,51 +154,18 @@ func LoadConfig(filename string) (*Config, error) {
 		ListenIngestion: "127.0.0.1:8765",
 		ListenUI:        "127.0.0.1:8080",
 		DatabasePath:    "hindsight.db",
-		RandomSaltSeed:  "", // leave this empty until after toml unmarshalling
 	}
-	_, err := toml.DecodeFile(filename, c)
+	var err error
+	c.meta, err = toml.DecodeFile(filename, c)
 	if err != nil {
 		return nil, fmt.Errorf("could not load config file from %q: %w", filename, err)
 	}
-	if c.RandomSaltSeed == "" {
-		// generate some random data.
-		buf := make([]byte, 16)
-		_, err = rand.Read(buf)
-		if err != nil {
-			return nil, fmt.Errorf("could not generate any randomness: %w", err)
-		}
-		c.RandomSaltSeed = base64.RawURLEncoding.EncodeToString(buf)
-		// we should write that back to the file...
-		// but I don't want to "blat" any comments.
-		//so we will read the entire file and replace that little bit
-		existing, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return nil, fmt.Errorf("could not read config file to add randomness: %w", err)
-		}
-		// we will rewrite the whole file
-		b := bytes.Buffer{}
-		index := bytes.Index(existing, []byte(`random_salt_seed`))
-		if index == -1 {
-			// just append
-			b.Write(existing)
-			fmt.Fprintf(&b, "\nrandom_salt_seed = %q # AUTO-GENERATED\n", c.RandomSaltSeed)
-		} else {
-			// replace, write data before
-			b.Write(existing[0:index])
-			// insert new value (we don't need the newline here)
-			fmt.Fprintf(&b, "random_salt_seed = %q # AUTO-GENERATED\n", c.RandomSaltSeed)
-			// find the original next newline
-			newline := bytes.IndexByte(existing[index:], '\n')
-			if newline != -1 {
-				// if it was -1, then there was no trailing newline, so we don't have any more file.
-				// but if not, then we should write the rest.
-				b.Write(existing[index+newline+1:])
-			}
-		}
-		err = ioutil.WriteFile(filename, b.Bytes(), 0644)
-		if err != nil {
-			return nil, fmt.Errorf("failed to rewrite config file: %w", err)
-		}
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("invalid config in %q: %w", filename, err)
+	}
+	if c.meta.IsDefined("random_salt_seed") {
+		// the salts are now random and only kept as long as they are needed.
+		log.Warn().Str("file", filename).Msg("random_salt_seed is no longer used, and can be removed from the config")
 	}
 	return c, nil
 }
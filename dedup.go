@@ -0,0 +1,112 @@
+package hindsight
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type dedupOptions struct {
+	Window     string `toml:"window"`      // how far apart (in event time) duplicates can be
+	MaxEntries int    `toml:"max_entries"` // how many recent events to remember at most
+}
+
+type fingerprint [16]byte
+
+type seenEvent struct {
+	fp fingerprint
+	t  time.Time
+}
+
+// Deduplicator remembers the fingerprints of recent events, so we can
+// discard the same event if it arrives twice, e.g. from a client retrying
+// a send or from importing overlapping log files.
+type Deduplicator struct {
+	window time.Duration
+	max    int
+
+	mu     sync.Mutex
+	seen   map[fingerprint]struct{}
+	queue  []seenEvent // in the order seen, for eviction
+	newest time.Time
+}
+
+func NewDeduplicator(window time.Duration, maxEntries int) *Deduplicator {
+	return &Deduplicator{
+		window: window,
+		max:    maxEntries,
+		seen:   make(map[fingerprint]struct{}, maxEntries),
+	}
+}
+
+// eventFingerprint uses the client generated ID if there is one, otherwise
+// the content of the event. The event must already have its visitor key.
+func eventFingerprint(in *InboundEvent, ev *Event) fingerprint {
+	h := sha256.New()
+	if in.ID != "" {
+		fmt.Fprintf(h, "id\n%s\n%s", ev.Host, in.ID)
+	} else {
+		fmt.Fprintf(h, "content\n%d\n%s\n%s\n%s\n%d\n%d",
+			in.Time.UnixNano(), ev.Key, ev.Host, in.Path, in.StatusCode, in.BytesWritten)
+	}
+	var fp fingerprint
+	copy(fp[:], h.Sum(nil))
+	return fp
+}
+
+// IsDuplicate records the event, and returns whether it was seen before.
+// Anonymous events without an ID are never duplicates, as different people
+// can send the same content.
+func (d *Deduplicator) IsDuplicate(in *InboundEvent, ev *Event) bool {
+	if in.ID == "" && ev.Key == "" {
+		return false
+	}
+	fp := eventFingerprint(in, ev)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.seen[fp]; ok {
+		return true
+	}
+	if in.Time.After(d.newest) {
+		d.newest = in.Time
+	}
+	d.seen[fp] = struct{}{}
+	d.queue = append(d.queue, seenEvent{fp: fp, t: in.Time})
+	// evict anything too old or too many.
+	evict := 0
+	for evict < len(d.queue) && (len(d.queue)-evict > d.max || d.newest.Sub(d.queue[evict].t) > d.window) {
+		delete(d.seen, d.queue[evict].fp)
+		evict++
+	}
+	if evict > 0 {
+		d.queue = append(d.queue[:0], d.queue[evict:]...)
+	}
+	return false
+}
+
+// discards events we have seen recently.
+func newDedupProcessor(c *Config, _ *Services, decode func(v interface{}) error) (Processor, error) {
+	opts := &dedupOptions{
+		Window:     "10m",
+		MaxEntries: 100000,
+	}
+	if err := decode(opts); err != nil {
+		return nil, err
+	}
+	window, err := time.ParseDuration(opts.Window)
+	if err != nil {
+		return nil, fmt.Errorf("bad window: %w", err)
+	}
+	if opts.MaxEntries < 1 {
+		return nil, fmt.Errorf("max_entries should be at least 1")
+	}
+	d := NewDeduplicator(window, opts.MaxEntries)
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if d.IsDuplicate(in, ev) {
+			metricDuplicatesDiscarded.Add(1)
+			return ErrDropEvent
+		}
+		return nil
+	}), nil
+}
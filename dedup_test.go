@@ -0,0 +1,52 @@
+package hindsight
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeduplicator(t *testing.T) {
+	d := NewDeduplicator(10*time.Minute, 100)
+	now := time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)
+	event := func(id, key, path string, at time.Time) (*InboundEvent, *Event) {
+		return &InboundEvent{ID: id, Time: at, Path: path, StatusCode: 200},
+			&Event{Key: key, Host: "example.com"}
+	}
+	cases := []struct {
+		name      string
+		id, key   string
+		path      string
+		at        time.Time
+		duplicate bool
+	}{
+		{"first", "", "a", "/", now, false},
+		{"same content", "", "a", "/", now, true},
+		{"another visitor", "", "b", "/", now, false},
+		{"another page", "", "a", "/x", now, false},
+		{"anonymous", "", "", "/", now, false},
+		{"another anonymous visitor", "", "", "/", now, false},
+		{"with an id", "id-1", "", "/", now, false},
+		{"the id again", "id-1", "", "/other", now, true},
+		{"later", "", "a", "/", now.Add(time.Second), false},
+	}
+	for _, c := range cases {
+		in, ev := event(c.id, c.key, c.path, c.at)
+		if dup := d.IsDuplicate(in, ev); dup != c.duplicate {
+			t.Errorf("%s: expected duplicate %v, got %v", c.name, c.duplicate, dup)
+		}
+	}
+	// forgotten once outside the window
+	in, ev := event("", "a", "/", now.Add(20*time.Minute))
+	d.IsDuplicate(in, ev)
+	if in, ev := event("", "a", "/", now); d.IsDuplicate(in, ev) {
+		t.Error("expected the event to be forgotten after the window")
+	}
+}
+
+func TestDedupNotDefault(t *testing.T) {
+	for _, name := range DefaultProcessors {
+		if name == "dedup" {
+			t.Error("expected dedup to be opt-in")
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package hindsight
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExportConfig sets up the differentially private exports.
+type ExportConfig struct {
+	Epsilon     float64 `toml:"epsilon"`      // default privacy cost of one export
+	Budget      float64 `toml:"budget"`       // total epsilon allowed for the data in each period
+	Period      string  `toml:"period"`       // "day", "week", "month" or "year"
+	MaxRows     int     `toml:"max_rows"`     // rows a single visitor can count towards
+	MaxHits     int     `toml:"max_hits"`     // hits a single visitor can count for
+	MinVisitors int     `toml:"min_visitors"` // rows with fewer noisy visitors are left out
+}
+
+func (ec *ExportConfig) init() error {
+	if ec.Epsilon == 0 {
+		ec.Epsilon = 1
+	}
+	if ec.Budget == 0 {
+		ec.Budget = 4
+	}
+	if ec.Period == "" {
+		ec.Period = "month"
+	}
+	if ec.MaxRows == 0 {
+		ec.MaxRows = 5
+	}
+	if ec.MaxHits == 0 {
+		ec.MaxHits = 20
+	}
+	if ec.MinVisitors == 0 {
+		ec.MinVisitors = 10
+	}
+	if ec.Epsilon < 0 || ec.Budget < 0 {
+		return fmt.Errorf("export epsilon and budget must be positive")
+	}
+	switch ec.Period {
+	case "day", "week", "month", "year":
+	default:
+		return fmt.Errorf("unknown export period %q", ec.Period)
+	}
+	if ec.MaxRows < 0 || ec.MaxHits < 0 {
+		return fmt.Errorf("export max_rows and max_hits must be at least 1")
+	}
+	return nil
+}
+
+// ErrBudgetExhausted is returned when an export would spend more of the
+// privacy budget than is left for a period.
+var ErrBudgetExhausted = errors.New("privacy budget exhausted")
+
+// BudgetStore records how much of the privacy budget has been spent on
+// the data in each period.
+type BudgetStore interface {
+	// SpendBudget adds epsilon to the spend for each period, as long as
+	// none would go over the budget, otherwise it spends nothing and
+	// returns ErrBudgetExhausted.
+	SpendBudget(periods []string, epsilon, budget float64) error
+	// SpentBudget lists the spend for each period so far.
+	SpentBudget() (map[string]float64, error)
+}
+
+// budgetPeriods lists the periods the time range overlaps.
+func budgetPeriods(period string, from, until time.Time) []string {
+	var periods []string
+	seen := map[string]bool{}
+	// from the start of the first day, so stepping a day at a time can't
+	// skip over the day (and period) until is in.
+	from = from.UTC()
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	for t := start; !t.After(until); t = t.AddDate(0, 0, 1) {
+		var p string
+		switch period {
+		case "day":
+			p = t.Format("2006-01-02")
+		case "week":
+			y, w := t.ISOWeek()
+			p = fmt.Sprintf("%d-W%02d", y, w)
+		case "month":
+			p = t.Format("2006-01")
+		default:
+			p = t.Format("2006")
+		}
+		if !seen[p] {
+			seen[p] = true
+			periods = append(periods, p)
+		}
+	}
+	return periods
+}
+
+// keyPeriods is how many visitor key periods the time range overlaps. A
+// person gets a new key each period, so can count as that many visitors.
+func keyPeriods(rotation Rotation, from, until time.Time) int {
+	s := &Salts{rotation: rotation}
+	first, last := s.period(from), s.period(until)
+	if rotation == RotateWeekly {
+		return int((last-first)/7) + 1
+	}
+	return int(last-first) + 1
+}
+
+// laplace draws from the Laplace distribution centred on 0 with the given scale.
+func laplace(scale float64) (float64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("could not generate any randomness: %w", err)
+	}
+	// uniform in (-0.5, 0.5)
+	u := (float64(binary.BigEndian.Uint64(b[:])>>11)+0.5)/(1<<53) - 0.5
+	sign := 1.0
+	if u < 0 {
+		sign = -1.0
+	}
+	return -scale * sign * math.Log(1-2*math.Abs(u)), nil
+}
+
+// like aggregate, but each visitor counts towards at most maxRows rows and
+// for at most maxHits hits, in time order, which bounds the sensitivity.
+// Anonymous events (with no key) are left out, as there is no way to bound
+// how much whoever sent them contributes.
+func aggregateBounded(events []*Event, dims []*Dimension, maxRows, maxHits int) []*ReportRow {
+	sorted := append([]*Event(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	type contribution struct {
+		rows map[string]bool
+		hits int
+	}
+	contributions := map[string]*contribution{}
+	bounded := make([]*Event, 0, len(sorted))
+	values := make([]string, len(dims))
+	for _, ev := range sorted {
+		v := ev.visitor()
+		if v == "" {
+			continue
+		}
+		c, ok := contributions[v]
+		if !ok {
+			c = &contribution{rows: map[string]bool{}}
+			contributions[v] = c
+		}
+		for i, d := range dims {
+			values[i] = d.Value(ev)
+		}
+		k := strings.Join(values, "\x00")
+		if !c.rows[k] && len(c.rows) >= maxRows {
+			continue
+		}
+		if c.hits >= maxHits {
+			continue
+		}
+		c.rows[k] = true
+		c.hits++
+		bounded = append(bounded, ev)
+	}
+	return aggregate(bounded, dims)
+}
+
+// PrivateReportResult is a differentially private report. Unlike a
+// ReportResult it only has the noisy rows and what the query asked for, as
+// anything else worked out from the exact data (like how many rows were left
+// out) would give it away.
+type PrivateReportResult struct {
+	Name, Title string
+	From, Until time.Time
+	Classes     []Class
+	Columns     []string
+	Rows        []*ReportRow
+	// the noisy hits in the rows. the total visitors cannot be worked out
+	// from the rows, and it would cost more budget to release.
+	TotalHits int64
+	Epsilon   float64 // the privacy cost of this result
+}
+
+// RunPrivateReport runs a report and adds Laplace noise to the counts, so that
+// the result is differentially private with the given epsilon, which is split
+// equally between the visitor and hit counts. The epsilon is spent from the
+// budget of every period the query covers before any data is released.
+// Visitor keys change every day (or week), so the noise is scaled by the
+// number of keys a person can have had over the query.
+//
+// Rows with fewer noisy visitors than the export (or report) minimum are left
+// out, as the presence of a row would otherwise give away a visitor.
+func RunPrivateReport(c *Config, store Storage, budget BudgetStore, r *Report, q *ReportQuery, epsilon float64) (*PrivateReportResult, error) {
+	ec := &c.Export
+	if !r.Available(c) {
+		return nil, ErrReportDisabled
+	}
+	if epsilon <= 0 {
+		return nil, fmt.Errorf("epsilon must be positive")
+	}
+	if c.Identity.Rotation == RotateSession {
+		// a person can have any number of session keys, so can't be bounded.
+		return nil, fmt.Errorf("private reports are not possible with session visitor keys")
+	}
+	filter := r.filter(q)
+	periods := budgetPeriods(ec.Period, q.From, q.Until)
+	if err := budget.SpendBudget(periods, epsilon, ec.Budget); err != nil {
+		return nil, err
+	}
+	events, err := store.Fetch(q.From, q.Until, &filter)
+	if err != nil {
+		return nil, err
+	}
+	dims := r.dimensions(c)
+	rows := aggregateBounded(events, dims, ec.MaxRows, ec.MaxHits)
+	// the bounds are per visitor key, and a person has a key for each
+	// period, so can contribute that many times over.
+	keys := float64(keyPeriods(c.Identity.Rotation, q.From, q.Until))
+	visitorScale := keys * float64(ec.MaxRows) / (epsilon / 2)
+	hitScale := keys * float64(ec.MaxHits) / (epsilon / 2)
+	threshold := ec.MinVisitors
+	if c.Reports.MinVisitors > threshold {
+		threshold = c.Reports.MinVisitors
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	res := &PrivateReportResult{
+		Name:    r.Name,
+		Title:   r.Title,
+		From:    q.From,
+		Until:   q.Until,
+		Classes: filter.Classes,
+		Columns: make([]string, len(dims)),
+		Epsilon: epsilon,
+	}
+	for i, d := range dims {
+		res.Columns[i] = d.Name
+	}
+	for _, row := range rows {
+		vn, err := laplace(visitorScale)
+		if err != nil {
+			return nil, err
+		}
+		hn, err := laplace(hitScale)
+		if err != nil {
+			return nil, err
+		}
+		row.Visitors = int64(math.Round(math.Max(0, float64(row.Visitors)+vn)))
+		row.Hits = int64(math.Round(math.Max(0, float64(row.Hits)+hn)))
+		if row.Visitors < int64(threshold) {
+			continue
+		}
+		if row.Hits < row.Visitors {
+			// every visitor has at least one hit
+			row.Hits = row.Visitors
+		}
+		res.Rows = append(res.Rows, row)
+		res.TotalHits += row.Hits
+	}
+	sort.Slice(res.Rows, func(i, j int) bool { return res.Rows[i].Visitors > res.Rows[j].Visitors })
+	res.Rows = r.order(res.Rows, q.Limit)
+	return res, nil
+}
@@ -0,0 +1,144 @@
+package hindsight
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBudgetPeriods(t *testing.T) {
+	from := time.Date(2021, 12, 30, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2022, 1, 4, 0, 0, 0, 0, time.UTC)
+	cases := map[string][]string{
+		"month": {"2021-12", "2022-01"},
+		"year":  {"2021", "2022"},
+		"week":  {"2021-W52", "2022-W01"},
+	}
+	for period, expected := range cases {
+		if actual := budgetPeriods(period, from, until); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", period, expected, actual)
+		}
+	}
+	// until is earlier in its day than from, but still in the next period
+	from = time.Date(2022, 1, 31, 12, 0, 0, 0, time.UTC)
+	until = time.Date(2022, 2, 1, 6, 0, 0, 0, time.UTC)
+	cases = map[string][]string{
+		"day":   {"2022-01-31", "2022-02-01"},
+		"month": {"2022-01", "2022-02"},
+		"year":  {"2022"},
+	}
+	for period, expected := range cases {
+		if actual := budgetPeriods(period, from, until); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s across a boundary: expected %v, got %v", period, expected, actual)
+		}
+	}
+}
+
+func TestAggregateBounded(t *testing.T) {
+	now := time.Now()
+	var events []*Event
+	// one visitor on lots of pages, lots of times
+	for i := 0; i < 10; i++ {
+		for _, p := range []string{"/a", "/b", "/c"} {
+			events = append(events, &Event{Key: "x", Time: now.Add(time.Duration(i) * time.Second), Path: p})
+		}
+	}
+	rows := aggregateBounded(events, []*Dimension{DimPath}, 2, 5)
+	var visitors, hits int64
+	for _, row := range rows {
+		visitors += row.Visitors
+		hits += row.Hits
+	}
+	if len(rows) != 2 || visitors != 2 || hits != 5 {
+		t.Errorf("expected the visitor to count for 2 rows and 5 hits, got %d rows, %d visitors and %d hits", len(rows), visitors, hits)
+	}
+}
+
+func TestAggregateBoundedAnonymous(t *testing.T) {
+	now := time.Now()
+	events := []*Event{
+		{Key: "x", Time: now, Path: "/a"},
+		// anonymous, so can't be bounded
+		{Time: now, Path: "/a"},
+		{Time: now, Path: "/b"},
+	}
+	rows := aggregateBounded(events, []*Dimension{DimPath}, 5, 20)
+	if len(rows) != 1 || rows[0].Values[0] != "/a" || rows[0].Hits != 1 {
+		t.Errorf("expected only the visitor's hit, got %d rows", len(rows))
+	}
+}
+
+func TestKeyPeriods(t *testing.T) {
+	// a Monday to the Monday a fortnight later
+	from := time.Date(2022, 1, 3, 12, 0, 0, 0, time.UTC)
+	until := time.Date(2022, 1, 17, 0, 0, 0, 0, time.UTC)
+	if n := keyPeriods(RotateDaily, from, until); n != 15 {
+		t.Errorf("expected 15 days, got %d", n)
+	}
+	if n := keyPeriods(RotateWeekly, from, until); n != 3 {
+		t.Errorf("expected 3 weeks, got %d", n)
+	}
+	if n := keyPeriods(RotateDaily, from, from); n != 1 {
+		t.Errorf("expected 1 day, got %d", n)
+	}
+}
+
+func TestRunPrivateReport(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/dp.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2022, 1, 10, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		ev := &Event{
+			Time: from.Add(time.Duration(i) * time.Minute), Host: "example.com", Path: "/",
+			Class: ClassPageview, Device: string(DeviceDesktop), CountryCode: "GB",
+			Key: fmt.Sprintf("visitor-%d", i), Scheme: legacyScheme,
+		}
+		if err := store.Store(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// anonymous events are left out
+	if err := store.Store(&Event{Time: from, Host: "example.com", Path: "/", Class: ClassPageview, Device: string(DeviceDesktop)}); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Export: ExportConfig{Budget: 1500}}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	r := LookupReport("countries")
+	q := &ReportQuery{From: from, Until: from.Add(time.Hour)}
+	// a huge epsilon, so the noise is tiny
+	res, err := RunPrivateReport(c, store, store, r, q, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 || math.Abs(float64(res.Rows[0].Visitors-50)) > 1 {
+		t.Errorf("expected about 50 visitors from GB, got %+v", res.Rows)
+	}
+	if res.TotalHits != res.Rows[0].Hits || res.Epsilon != 1000 {
+		t.Errorf("expected the total of the noisy hits and the epsilon, got %d and %g", res.TotalHits, res.Epsilon)
+	}
+	spent, err := store.SpentBudget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent["2022-01"] != 1000 {
+		t.Errorf("expected 1000 of the budget spent, got %v", spent)
+	}
+	// this would go over the budget, so nothing is spent
+	if _, err := RunPrivateReport(c, store, store, r, q, 1000); !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("expected the budget to be exhausted, got %v", err)
+	}
+	if spent, _ := store.SpentBudget(); spent["2022-01"] != 1000 {
+		t.Errorf("expected no more of the budget spent, got %v", spent)
+	}
+	c.Identity.Rotation = RotateSession
+	if _, err := RunPrivateReport(c, store, store, r, q, 1); err == nil {
+		t.Error("expected private reports to be refused with session keys")
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"net"
 	"time"
-
-	"github.com/0x6377/hindsight/geoip"
 )
 
 type InboundEvent struct {
@@ -20,6 +18,22 @@ type InboundEvent struct {
 	StatusCode   int64         // must be a valid code
 	BytesWritten int64         // must be non-negative
 	Duration     time.Duration //`json:"-"`
+	ContentType  string        // optional, the response content-type
+	ID           string        // optional, client generated to detect duplicates
+	DNT          bool          // optional, the browser sent `DNT: 1`
+	GPC          bool          // optional, the browser sent `Sec-GPC: 1`
+	// optional, the Accept-Language header, only used in the visitor key
+	AcceptLanguage string
+	Excluded       bool // optional, the browser opted out, so the event is dropped
+}
+
+// the keys we accept in an inbound event, required or not.
+var inboundEventKeys = map[string]bool{
+	"Hindsight": true, "Time": true, "IP": true, "Host": true, "Method": true, "Path": true,
+	"UserAgent": true, "StatusCode": true, "BytesWritten": true, "DurationMS": true,
+	// optional
+	"ContentType": true, "ID": true, "DNT": true, "GPC": true, "AcceptLanguage": true,
+	"Excluded": true,
 }
 
 func (in *InboundEvent) UnmarshalJSON(b []byte) error {
@@ -36,9 +50,11 @@ func (in *InboundEvent) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err // plain bad JSON
 	}
-	// We should have 10 fields
-	if len(m) > 10 {
-		return fmt.Errorf("event has unexpected fields")
+	// We should have 10 required fields, and maybe some optional ones
+	for k := range m {
+		if !inboundEventKeys[k] {
+			return fmt.Errorf("event has unexpected field %q", k)
+		}
 	}
 	if err := unmarshalStringField(m, "Hindsight", func(s string) error {
 		if s != "1.0" {
@@ -73,7 +89,8 @@ func (in *InboundEvent) UnmarshalJSON(b []byte) error {
 	}
 	// Host
 	if err := unmarshalStringField(m, "Host", func(s string) error {
-		// Should I normalise? remove trailing dot, etc...
+		// normalisation happens when we map the event, as that
+		// depends on the configuration.
 		in.Host = s
 		return nil
 	}); err != nil {
@@ -132,6 +149,53 @@ func (in *InboundEvent) UnmarshalJSON(b []byte) error {
 	}); err != nil {
 		return err
 	}
+	// ID (optional)
+	if err := unmarshalOptionalStringField(m, "ID", func(s string) error {
+		if len(s) > 64 {
+			return fmt.Errorf("event 'ID' should be at most 64 characters")
+		}
+		in.ID = s
+		return nil
+	}); err != nil {
+		return err
+	}
+	// DNT and GPC (optional)
+	if err := unmarshalOptionalBoolField(m, "DNT", func(b bool) error {
+		in.DNT = b
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := unmarshalOptionalBoolField(m, "GPC", func(b bool) error {
+		in.GPC = b
+		return nil
+	}); err != nil {
+		return err
+	}
+	// ContentType (optional)
+	if err := unmarshalOptionalStringField(m, "ContentType", func(s string) error {
+		in.ContentType = s
+		return nil
+	}); err != nil {
+		return err
+	}
+	// Excluded (optional)
+	if err := unmarshalOptionalBoolField(m, "Excluded", func(b bool) error {
+		in.Excluded = b
+		return nil
+	}); err != nil {
+		return err
+	}
+	// AcceptLanguage (optional)
+	if err := unmarshalOptionalStringField(m, "AcceptLanguage", func(s string) error {
+		if len(s) > 256 {
+			return fmt.Errorf("event 'AcceptLanguage' should be at most 256 characters")
+		}
+		in.AcceptLanguage = s
+		return nil
+	}); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -147,6 +211,23 @@ func unmarshalStringField(m map[string]interface{}, key string, fn func(s string
 		}
 	}
 }
+func unmarshalOptionalStringField(m map[string]interface{}, key string, fn func(s string) error) error {
+	if _, ok := m[key]; !ok {
+		return nil
+	}
+	return unmarshalStringField(m, key, fn)
+}
+func unmarshalOptionalBoolField(m map[string]interface{}, key string, fn func(b bool) error) error {
+	if i, ok := m[key]; !ok {
+		return nil
+	} else {
+		if b, ok := i.(bool); !ok {
+			return fmt.Errorf("event %q was not a boolean", key)
+		} else {
+			return fn(b)
+		}
+	}
+}
 func unmarshalIntField(m map[string]interface{}, key string, fn func(n int64) error) error {
 	if i, ok := m[key]; !ok {
 		return fmt.Errorf("event missing the %q key", key)
@@ -167,14 +248,28 @@ func unmarshalIntField(m map[string]interface{}, key string, fn func(n int64) er
 // this is the anonymised event
 type Event struct {
 	Key                                string // from UA/IP/current time
+	Scheme                             string // how the Key was made, see IdentityConfig.Scheme
 	Time                               time.Time
 	Host, Path, Method                 string         // from request
+	Class                              Class          // from request/response
 	Device                             string         // from UA
 	Browser, OS                        NameAndVersion // from UA
 	CountryCode, TimeZone              string         // from IP
+	ASN                                int64          // from IP
+	ASNOrg                             string         // from IP
+	Region                             string         // from IP, only if privacy.regions
 	StatusCode, Duration, BytesWritten int64          // from response
 }
 
+// the key qualified by its scheme, as keys from different schemes are
+// different visitors. empty for anonymous events.
+func (ev *Event) visitor() string {
+	if ev.Key == "" {
+		return ""
+	}
+	return ev.Scheme + "\x00" + ev.Key
+}
+
 type NameAndVersion struct {
 	Name, Version string
 }
@@ -182,28 +277,3 @@ type NameAndVersion struct {
 func (nv *NameAndVersion) String() string {
 	return nv.Name + " " + nv.Version
 }
-
-func mapInboundEvent(c *Config, in *InboundEvent) *Event {
-	uainfo := DecodeUserAgent(in.UserAgent)
-	loc := geoip.MustGeolocate(net.ParseIP(in.IP))
-	return &Event{
-		Key:  UniqueKey(c, in),
-		Time: in.Time,
-
-		Device:  string(uainfo.Device),
-		Browser: uainfo.Browser,
-		OS:      uainfo.OS,
-
-		CountryCode: loc.CountryCode,
-		TimeZone:    loc.Timezone,
-
-		Host:   in.Host, // should we clean/canonicalise it?
-		Method: in.Method,
-		Path:   in.Path, // should we clean/canonicalise it?
-
-		Duration:     int64(in.Duration / time.Millisecond),
-		BytesWritten: in.BytesWritten,
-		StatusCode:   in.StatusCode,
-	}
-
-}
@@ -0,0 +1,176 @@
+package hindsight
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0x6377/hindsight/client"
+	"github.com/rs/zerolog/log"
+)
+
+// ExcludeConfig is traffic that should never be recorded, i.e. our own.
+type ExcludeConfig struct {
+	IPs          []string `toml:"ips"`           // addresses or CIDRs
+	UserAgents   []string `toml:"user_agents"`   // case-insensitive substrings
+	CookieDomain string   `toml:"cookie_domain"` // for the opt-out cookie, so every site gets it
+
+	nets []*net.IPNet
+}
+
+func (ec *ExcludeConfig) init() error {
+	ec.nets = make([]*net.IPNet, 0, len(ec.IPs))
+	for _, s := range ec.IPs {
+		n, err := parseIPNet(s)
+		if err != nil {
+			return fmt.Errorf("bad excluded ip: %w", err)
+		}
+		ec.nets = append(ec.nets, n)
+	}
+	for i, ua := range ec.UserAgents {
+		ec.UserAgents[i] = strings.ToLower(ua)
+	}
+	return nil
+}
+
+// parses a CIDR, or a single address as a network of one.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("bad address %q", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad network %q: %w", s, err)
+	}
+	return n, nil
+}
+
+// Excludes is whether the event is from an excluded address or user-agent,
+// or the browser has opted out.
+func (ec *ExcludeConfig) Excludes(in *InboundEvent) bool {
+	if in.Excluded {
+		return true
+	}
+	if len(ec.nets) > 0 {
+		if ip := net.ParseIP(in.IP); ip != nil {
+			for _, n := range ec.nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	if len(ec.UserAgents) > 0 {
+		ua := strings.ToLower(in.UserAgent)
+		for _, s := range ec.UserAgents {
+			if strings.Contains(ua, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// drops excluded events, this must come before anything changes the IP.
+func newExcludeProcessor(c *Config, _ *Services, _ func(v interface{}) error) (Processor, error) {
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if c.Exclude.Excludes(in) {
+			return ErrDropEvent
+		}
+		return nil
+	}), nil
+}
+
+// the opt-out cookie lasts as long as browsers let it.
+const excludeCookieAge = 400 * 24 * time.Hour
+
+// shows whether this browser is excluded, and lets it opt out (or back in).
+// The cookie is set for the exclude.cookie_domain, which must include the
+// sites, so the UI must be served from the same domain (e.g. a subdomain).
+// Changes must be posted from the page itself, so another site can't opt
+// its visitors out (or back in).
+func (ui *uiHandler) exclude(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		_, err := req.Cookie(client.ExcludeCookie)
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("X-Frame-Options", "DENY")
+		if err := excludeTemplate.Execute(rw, err == nil); err != nil {
+			log.Warn().Err(err).Msg("failed to render exclude page")
+		}
+	case http.MethodPost:
+		if !sameOrigin(req) {
+			http.Error(rw, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		cookie := &http.Cookie{
+			Name:     client.ExcludeCookie,
+			Value:    "1",
+			Path:     "/",
+			Domain:   ui.c.Exclude.CookieDomain,
+			MaxAge:   int(excludeCookieAge / time.Second),
+			HttpOnly: true,
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		}
+		if req.PostFormValue("exclude") != "1" {
+			cookie.Value, cookie.MaxAge = "", -1
+		}
+		http.SetCookie(rw, cookie)
+		http.Redirect(rw, req, req.URL.Path, http.StatusSeeOther)
+	default:
+		rw.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// whether the request came from a page on the same host, by the Origin
+// header browsers send with a POST, or the Referer if there isn't one.
+// Requests with neither, or from an opaque ("null") origin, are refused as
+// we can't tell where they came from.
+func sameOrigin(req *http.Request) bool {
+	from := req.Header.Get("Origin")
+	if from == "" {
+		from = req.Header.Get("Referer")
+	}
+	if from == "" || from == "null" {
+		return false
+	}
+	u, err := url.Parse(from)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+var excludeTemplate = template.Must(template.New("exclude").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Hindsight - Exclude</title>
+<style>body { font-family: sans-serif; margin: 2em; }</style>
+</head>
+<body>
+<h1>Exclude this browser</h1>
+{{if .}}
+<p>Visits from this browser are <strong>not</strong> recorded.</p>
+<form method="post"><input type="hidden" name="exclude" value="0"><button type="submit">Record my visits again</button></form>
+{{else}}
+<p>Visits from this browser are recorded.</p>
+<form method="post"><input type="hidden" name="exclude" value="1"><button type="submit">Stop recording my visits</button></form>
+{{end}}
+</body>
+</html>
+`))
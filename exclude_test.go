@@ -0,0 +1,89 @@
+package hindsight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/0x6377/hindsight/client"
+)
+
+func TestExcludes(t *testing.T) {
+	ec := &ExcludeConfig{
+		IPs:        []string{"192.0.2.0/24", "2001:db8::1"},
+		UserAgents: []string{"UptimeChecker"},
+	}
+	if err := ec.init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		in       *InboundEvent
+		excluded bool
+	}{
+		{&InboundEvent{IP: "192.0.2.99", UserAgent: "Firefox"}, true},
+		{&InboundEvent{IP: "198.51.100.1", UserAgent: "Firefox"}, false},
+		{&InboundEvent{IP: "2001:db8::1", UserAgent: "Firefox"}, true},
+		{&InboundEvent{IP: "2001:db8::2", UserAgent: "Firefox"}, false},
+		{&InboundEvent{IP: "198.51.100.1", UserAgent: "uptimechecker/1.0"}, true},
+		{&InboundEvent{IP: "198.51.100.1", UserAgent: "Firefox", Excluded: true}, true},
+	}
+	for _, c := range cases {
+		if actual := ec.Excludes(c.in); actual != c.excluded {
+			t.Errorf("Excludes(%s, %s): expected %v", c.in.IP, c.in.UserAgent, c.excluded)
+		}
+	}
+}
+
+func TestExcludeEndpoint(t *testing.T) {
+	c := &Config{}
+	c.Exclude.CookieDomain = "example.com"
+	h := NewUIHandler(c, nil)
+
+	form := url.Values{"exclude": {"1"}}
+	post := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://stats.example.com/exclude", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+	// only from the page itself
+	for _, headers := range []map[string]string{
+		nil,
+		{"Origin": "https://evil.example.org"},
+		{"Origin": "null"},
+		{"Referer": "https://evil.example.org/page"},
+	} {
+		if rw := post(headers); rw.Code != http.StatusForbidden || len(rw.Result().Cookies()) != 0 {
+			t.Errorf("%v: expected the request to be refused, got %d", headers, rw.Code)
+		}
+	}
+	if rw := post(map[string]string{"Referer": "https://stats.example.com/exclude"}); rw.Code != http.StatusSeeOther {
+		t.Errorf("expected a same origin referer to be allowed, got %d", rw.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://stats.example.com/exclude", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://stats.example.com")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	cookies := rw.Result().Cookies()
+	if rw.Code != http.StatusSeeOther || len(cookies) != 1 {
+		t.Fatalf("expected a redirect setting the cookie, got %d with %d cookies", rw.Code, len(cookies))
+	}
+	if cookie := cookies[0]; cookie.Name != client.ExcludeCookie || cookie.Value != "1" || cookie.Domain != "example.com" {
+		t.Errorf("unexpected cookie %v", cookie)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/exclude", nil)
+	req.AddCookie(cookies[0])
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if !strings.Contains(rw.Body.String(), "are <strong>not</strong> recorded") {
+		t.Error("expected the page to show the browser is excluded")
+	}
+}
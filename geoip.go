@@ -0,0 +1,187 @@
+package hindsight
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0x6377/hindsight/geoip"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultGeoIPReload = time.Minute
+
+// GeoIPConfig is where the geolocation data comes from. Without a database
+// the embedded one is used, if it was compiled in.
+type GeoIPConfig struct {
+	Kind           string `toml:"kind"`            // the kind of database, see geoip.Kinds, default maxmind
+	Database       string `toml:"database"`        // path to the location database
+	ASNKind        string `toml:"asn_kind"`        // the kind of ASN database, default maxmind
+	ASNDatabase    string `toml:"asn_database"`    // path to a MaxMind format ASN .mmdb
+	ReloadInterval string `toml:"reload_interval"` // how often to check the database for changes
+
+	// our own networks, labelled "internal:<name>" instead of a country
+	Internal map[string][]string `toml:"internal"`
+
+	// for `hindsight geoip update`, and scheduled updates
+	LicenseKey     string `toml:"license_key"`     // or from MAXMIND_LICENSE_KEY in the environment
+	Edition        string `toml:"edition"`         // default GeoLite2-City
+	ASNEdition     string `toml:"asn_edition"`     // default GeoLite2-ASN
+	DownloadURL    string `toml:"download_url"`    // see geoip.DefaultDownloadURL
+	UpdateInterval string `toml:"update_interval"` // update while running this often, if set
+
+	reloadInterval time.Duration
+	updateInterval time.Duration
+	internal       []geoip.InternalNetwork
+}
+
+func (gc *GeoIPConfig) init() error {
+	if gc.Kind == "" {
+		gc.Kind = "maxmind"
+	}
+	if gc.ASNKind == "" {
+		gc.ASNKind = "maxmind"
+	}
+	for _, kind := range []string{gc.Kind, gc.ASNKind} {
+		if !knownGeoIPKind(kind) {
+			return fmt.Errorf("unknown geoip kind %q, expected one of %s", kind, strings.Join(geoip.Kinds(), ", "))
+		}
+	}
+	gc.reloadInterval = defaultGeoIPReload
+	if gc.ReloadInterval != "" {
+		d, err := time.ParseDuration(gc.ReloadInterval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("geoip reload_interval should be a positive duration")
+		}
+		gc.reloadInterval = d
+	}
+	gc.internal = nil
+	for label, list := range gc.Internal {
+		if label == "" || strings.Contains(label, ":") {
+			return fmt.Errorf("bad geoip internal network name %q", label)
+		}
+		for _, s := range list {
+			n, err := parseIPNet(s)
+			if err != nil {
+				return fmt.Errorf("bad geoip internal network %s: %w", label, err)
+			}
+			gc.internal = append(gc.internal, geoip.InternalNetwork{Label: label, Net: n})
+		}
+	}
+	if gc.LicenseKey == "" {
+		gc.LicenseKey = os.Getenv("MAXMIND_LICENSE_KEY")
+	}
+	if gc.ASNEdition == "" {
+		gc.ASNEdition = geoip.DefaultASNEdition
+	}
+	if gc.UpdateInterval != "" {
+		d, err := time.ParseDuration(gc.UpdateInterval)
+		if err != nil || d < time.Hour {
+			return fmt.Errorf("geoip update_interval should be a duration of at least 1h")
+		}
+		if gc.Database == "" && gc.ASNDatabase == "" {
+			return fmt.Errorf("geoip update_interval needs a geoip database to update")
+		}
+		if gc.Kind != "maxmind" || gc.ASNKind != "maxmind" {
+			return fmt.Errorf("geoip update_interval can only update maxmind databases")
+		}
+		gc.updateInterval = d
+	}
+	return nil
+}
+
+func knownGeoIPKind(kind string) bool {
+	for _, k := range geoip.Kinds() {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Updater downloads the configured city database, or the ASN database.
+func (gc *GeoIPConfig) Updater(asn bool) *geoip.Updater {
+	edition := gc.Edition
+	if asn {
+		edition = gc.ASNEdition
+	}
+	return &geoip.Updater{
+		URL:        gc.DownloadURL,
+		Edition:    edition,
+		LicenseKey: gc.LicenseKey,
+	}
+}
+
+// UpdateGeoIP downloads the city (or ASN) database to dest, returning whether
+// a new one was installed.
+func UpdateGeoIP(ctx context.Context, c *Config, dest string, asn, force bool) (bool, error) {
+	return c.GeoIP.Updater(asn).Update(ctx, dest, force)
+}
+
+// updates the databases every interval, straight away if they are missing.
+// The file locators pick up the new files.
+func updateGeoIP(ctx context.Context, c *Config) {
+	update := func(dest string, asn, missing bool) {
+		if dest == "" {
+			return
+		}
+		if _, err := os.Stat(dest); missing && err == nil {
+			return
+		}
+		installed, err := UpdateGeoIP(ctx, c, dest, asn, false)
+		if err != nil {
+			log.Warn().Err(err).Str("path", dest).Msg("failed to update geoip db")
+		} else if installed {
+			log.Info().Str("path", dest).Msg("updated geoip db")
+		}
+	}
+	update(c.GeoIP.Database, false, true)
+	update(c.GeoIP.ASNDatabase, true, true)
+	t := time.NewTicker(c.GeoIP.updateInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			update(c.GeoIP.Database, false, false)
+			update(c.GeoIP.ASNDatabase, true, false)
+		}
+	}
+}
+
+// loads the database at path and watches it, if there is one.
+func watchGeoIP(ctx context.Context, c *Config, kind, path string, fallback geoip.Geolocater) geoip.Geolocater {
+	if path == "" {
+		return fallback
+	}
+	fl, err := geoip.NewFileLocator(kind, path, fallback)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("failed to load geoip db, using the fallback until it can be")
+	}
+	go fl.Watch(ctx, c.GeoIP.reloadInterval)
+	return fl
+}
+
+// StartGeoIP sets up the configured geolocation databases, watching them for
+// changes (and updating them, if configured) until the context is done. If
+// the database can't be loaded, the embedded database (or nothing) is used
+// until it can. It also becomes the geoip.Default.
+func StartGeoIP(ctx context.Context, c *Config) geoip.Geolocater {
+	if c.GeoIP.Database == "" {
+		if _, err := geoip.Embedded(); err != nil {
+			log.Warn().Err(err).Msg("no geoip database, all locations will be unknown")
+		}
+	}
+	loc := watchGeoIP(ctx, c, c.GeoIP.Kind, c.GeoIP.Database, geoip.Fallback())
+	if c.GeoIP.ASNDatabase != "" {
+		loc = geoip.Merge(loc, watchGeoIP(ctx, c, c.GeoIP.ASNKind, c.GeoIP.ASNDatabase, geoip.Noop{}))
+	}
+	if c.GeoIP.updateInterval > 0 {
+		go updateGeoIP(ctx, c)
+	}
+	geoip.SetDefault(loc)
+	return loc
+}
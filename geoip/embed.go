@@ -0,0 +1,21 @@
+//go:build !noembed
+// +build !noembed
+
+package geoip
+
+import (
+	_ "embed"
+)
+
+// we generate the data we need from a MaxMind GeoLite2 databases
+// Of course we will need to embed the data. Build with `-tags noembed`
+// to leave it out, and load one from disk instead. It is stripped from a
+// downloaded database with `GEOIP_SOURCE=GeoLite2-City.mmdb go generate`.
+//
+//go:generate go run -tags noembed gen_embedded.go
+//go:embed maxmind-geolite2-city.mmdb
+var embeddedCityData []byte
+
+func init() {
+	cityData = embeddedCityData
+}
@@ -0,0 +1,92 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileLocator looks up a database file of any registered kind, reloading
+// it when it changes. Lookups never wait for a reload, the new database is swapped in
+// once it has loaded. If the file can't be loaded the previous database
+// (or the fallback) keeps being used.
+type FileLocator struct {
+	kind     string
+	path     string
+	fallback Geolocater
+
+	current    atomic.Value // holds a *fileState
+	generation uint64       // incremented on each load, use atomically
+}
+
+type fileState struct {
+	loc     Geolocater
+	modTime time.Time
+	size    int64
+}
+
+// NewFileLocator loads the database of the kind (see Register) at path. If
+// that fails, the returned locator still works, using the fallback until
+// the file can be loaded.
+func NewFileLocator(kind, path string, fallback Geolocater) (*FileLocator, error) {
+	if fallback == nil {
+		fallback = Noop{}
+	}
+	fl := &FileLocator{kind: kind, path: path, fallback: fallback}
+	fl.current.Store(&fileState{loc: fallback})
+	_, err := fl.Reload()
+	return fl, err
+}
+
+func (fl *FileLocator) Geolocate(ip net.IP) (*LookupResult, error) {
+	return fl.current.Load().(*fileState).loc.Geolocate(ip)
+}
+
+// Reload loads the file again if it has changed, returning whether it did.
+func (fl *FileLocator) Reload() (bool, error) {
+	info, err := os.Stat(fl.path)
+	if err != nil {
+		return false, fmt.Errorf("could not read geoip db: %w", err)
+	}
+	cur := fl.current.Load().(*fileState)
+	if info.ModTime().Equal(cur.modTime) && info.Size() == cur.size {
+		return false, nil
+	}
+	// the locators read it all, so the file can be replaced while we use it.
+	loc, err := Open(fl.kind, fl.path)
+	if err != nil {
+		return false, err
+	}
+	fl.current.Store(&fileState{loc: loc, modTime: info.ModTime(), size: info.Size()})
+	atomic.AddUint64(&fl.generation, 1)
+	return true, nil
+}
+
+// Generation is the number of times the file has been loaded.
+func (fl *FileLocator) Generation() uint64 {
+	return atomic.LoadUint64(&fl.generation)
+}
+
+// Watch checks the file for changes every interval, until the context is done.
+func (fl *FileLocator) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reloaded, err := fl.Reload()
+			if err != nil {
+				log.Warn().Err(err).Str("path", fl.path).Msg("failed to reload geoip db")
+			} else if reloaded {
+				log.Info().Str("path", fl.path).Msg("reloaded geoip db")
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLocatorFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	fl, err := NewFileLocator("maxmind", path, nil)
+	if err == nil {
+		t.Error("expected an error for a missing database")
+	}
+	if _, err := fl.Geolocate(net.ParseIP("192.0.2.1")); err != ErrUnknown {
+		t.Errorf("expected the fallback to be used, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := fl.Reload(); reloaded || err == nil {
+		t.Error("expected a bad database not to be loaded")
+	}
+	if _, err := fl.Geolocate(net.ParseIP("192.0.2.1")); err != ErrUnknown {
+		t.Errorf("expected the fallback to still be used, got %v", err)
+	}
+}
+
+func TestFileLocatorReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "city.mmdb")
+	install := func(fixture string) {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// replaced the way the updater does it
+		tmp := filepath.Join(dir, "city.mmdb.tmp")
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	country := func(fl *FileLocator, ip string) string {
+		res, err := fl.Geolocate(net.ParseIP(ip))
+		if err != nil {
+			return ""
+		}
+		return res.CountryCode
+	}
+	install("city-ipv4.mmdb")
+	fl, err := NewFileLocator("maxmind", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc := country(fl, "81.2.69.1"); cc != "GB" {
+		t.Errorf("expected GB, got %q", cc)
+	}
+	if cc := country(fl, "2001:db8:1::1"); cc != "" {
+		t.Errorf("expected an IPv6 address to be unknown, got %q", cc)
+	}
+	gen := fl.Generation()
+	if reloaded, err := fl.Reload(); reloaded || err != nil {
+		t.Errorf("expected no reload for an unchanged file, got %v %v", reloaded, err)
+	}
+
+	install("city.mmdb")
+	if reloaded, err := fl.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected the new database to be loaded, got %v %v", reloaded, err)
+	}
+	if cc := country(fl, "2001:db8:1::1"); cc != "DE" {
+		t.Errorf("expected DE from the new database, got %q", cc)
+	}
+	if cc := country(fl, "81.2.69.1"); cc != "GB" {
+		t.Errorf("expected GB, got %q", cc)
+	}
+	if fl.Generation() != gen+1 {
+		t.Errorf("expected the generation to go from %d to %d, got %d", gen, gen+1, fl.Generation())
+	}
+
+	// a broken replacement keeps the last good database
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := fl.Reload(); reloaded || err == nil {
+		t.Error("expected a bad database not to be loaded")
+	}
+	if cc := country(fl, "2001:db8:1::1"); cc != "DE" {
+		t.Errorf("expected the previous database to still be used, got %q", cc)
+	}
+}
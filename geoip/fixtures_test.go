@@ -0,0 +1,153 @@
+package geoip
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateFixtures = flag.Bool("update", false, "rewrite the fixture databases in testdata")
+
+type fixtureNetwork struct {
+	network string
+	value   map[string]interface{}
+}
+
+// the fixture databases, written with Writer. After changing them, run
+// `go test -run TestFixtures -update` to rewrite the files.
+var fixtures = []struct {
+	name      string
+	dbType    string
+	ipVersion int
+	networks  []fixtureNetwork
+}{
+	{"city.mmdb", "Test-City", 6, []fixtureNetwork{
+		{"81.2.69.0/24", map[string]interface{}{
+			"country":      map[string]interface{}{"iso_code": "GB"},
+			"location":     map[string]interface{}{"time_zone": "Europe/London"},
+			"subdivisions": []interface{}{map[string]interface{}{"iso_code": "ENG"}},
+		}},
+		{"2001:db8:1::/48", map[string]interface{}{
+			"country":  map[string]interface{}{"iso_code": "DE"},
+			"location": map[string]interface{}{"time_zone": "Europe/Berlin"},
+		}},
+		// no country
+		{"203.0.113.0/24", map[string]interface{}{
+			"location": map[string]interface{}{"time_zone": "Asia/Tokyo"},
+		}},
+		// no timezone
+		{"198.51.100.0/24", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "US"},
+		}},
+	}},
+	{"asn.mmdb", "Test-ASN", 6, []fixtureNetwork{
+		{"81.2.69.0/24", map[string]interface{}{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example Networks",
+		}},
+		{"2001:db8:1::/48", map[string]interface{}{
+			"autonomous_system_number": uint32(64501),
+		}},
+	}},
+	{"city-ipv4.mmdb", "Test-City", 4, []fixtureNetwork{
+		{"81.2.69.0/24", map[string]interface{}{
+			"country":  map[string]interface{}{"iso_code": "GB"},
+			"location": map[string]interface{}{"time_zone": "Europe/London"},
+		}},
+	}},
+}
+
+// checks the files in testdata are what the fixtures would write.
+func TestFixtures(t *testing.T) {
+	for _, f := range fixtures {
+		w, err := NewWriter(f.dbType, f.ipVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.BuildEpoch = 1640995200 // 2022-01-01, so the files don't change
+		for _, n := range f.networks {
+			if err := w.Insert(mustCIDR(t, n.network), n.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join("testdata", f.name)
+		if *updateFixtures {
+			if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, buf.Bytes()) {
+			t.Errorf("%s is out of date, run `go test -run TestFixtures -update`", path)
+		}
+	}
+}
+
+func openFixture(t *testing.T, name string) Geolocater {
+	l, err := Open("maxmind", filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestMaxmind(t *testing.T) {
+	city := openFixture(t, "city.mmdb")
+	asn := openFixture(t, "asn.mmdb")
+	cityv4 := openFixture(t, "city-ipv4.mmdb")
+	cases := []struct {
+		name string
+		l    Geolocater
+		ip   string
+		// from Geolocate, nil for ErrUnknown
+		res *LookupResult
+		// from MustGeolocateWith
+		country, tz string
+	}{
+		{"ipv4", city, "81.2.69.160", &LookupResult{CountryCode: "GB", Timezone: "Europe/London", Region: "GB-ENG"}, "GB", "Europe/London"},
+		{"ipv6", city, "2001:db8:1::1", &LookupResult{CountryCode: "DE", Timezone: "Europe/Berlin"}, "DE", "Europe/Berlin"},
+		{"mapped ipv4", city, "::ffff:81.2.69.1", &LookupResult{CountryCode: "GB", Timezone: "Europe/London", Region: "GB-ENG"}, "GB", "Europe/London"},
+		{"no country", city, "203.0.113.9", &LookupResult{Timezone: "Asia/Tokyo"}, "XX", "Asia/Tokyo"},
+		{"no timezone", city, "198.51.100.9", &LookupResult{CountryCode: "US"}, "US", "Etc/UTC"},
+		{"unknown ipv4", city, "192.0.2.1", nil, "XX", "Etc/UTC"},
+		{"unknown ipv6", city, "2001:db8:2::1", nil, "XX", "Etc/UTC"},
+		{"asn", asn, "81.2.69.1", &LookupResult{ASN: 64500, ASNOrg: "Example Networks"}, "XX", "Etc/UTC"},
+		{"asn without org", asn, "2001:db8:1::1", &LookupResult{ASN: 64501}, "XX", "Etc/UTC"},
+		{"city and asn", Merge(city, asn), "81.2.69.1", &LookupResult{CountryCode: "GB", Timezone: "Europe/London", Region: "GB-ENG", ASN: 64500, ASNOrg: "Example Networks"}, "GB", "Europe/London"},
+		{"ipv4 only", cityv4, "81.2.69.1", &LookupResult{CountryCode: "GB", Timezone: "Europe/London"}, "GB", "Europe/London"},
+	}
+	for _, c := range cases {
+		ip := net.ParseIP(c.ip)
+		res, err := c.l.Geolocate(ip)
+		switch {
+		case c.res == nil && err != ErrUnknown:
+			t.Errorf("%s: expected ErrUnknown, got %#v, %v", c.name, res, err)
+		case c.res != nil && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.res != nil && *res != *c.res:
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.res, res)
+		}
+		must := MustGeolocateWith(c.l, ip)
+		if must.CountryCode != c.country || must.Timezone != c.tz {
+			t.Errorf("%s: expected %s %s by default, got %#v", c.name, c.country, c.tz, must)
+		}
+	}
+	// an IPv6 address can't be looked up in an IPv4 database at all
+	if _, err := cityv4.Geolocate(net.ParseIP("2001:db8:1::1")); err == nil || err == ErrUnknown {
+		t.Errorf("expected an error looking up IPv6 in an IPv4 database, got %v", err)
+	}
+	if must := MustGeolocateWith(cityv4, net.ParseIP("2001:db8:1::1")); must.CountryCode != "XX" || must.Timezone != "Etc/UTC" {
+		t.Errorf("expected the defaults on error, got %#v", must)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build ignore
+// +build ignore
+
+// Strips a downloaded GeoLite2 City database into the one we embed, run
+// with `GEOIP_SOURCE=path/to/GeoLite2-City.mmdb go generate ./geoip`.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/0x6377/hindsight/geoip"
+)
+
+func main() {
+	src := flag.String("src", os.Getenv("GEOIP_SOURCE"), "the database to strip (default $GEOIP_SOURCE)")
+	out := flag.String("out", "maxmind-geolite2-city.mmdb", "where to write the stripped database")
+	regions := flag.Bool("regions", false, "keep the regions (subdivisions)")
+	flag.Parse()
+	if *src == "" {
+		log.Fatal("no database to strip, set GEOIP_SOURCE or -src")
+	}
+	data, err := os.ReadFile(*src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stripped, stats, err := geoip.Strip(data, geoip.StripOptions{Regions: *regions})
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(*out), ".geoip-*.mmdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(stripped); err != nil {
+		log.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), *out); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("stripped %s to %s: kept %d of %d networks, %d bytes from %d", *src, *out, stats.Kept, stats.Networks, len(stripped), len(data))
+}
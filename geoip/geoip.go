@@ -9,6 +9,9 @@ import (
 type LookupResult struct {
 	CountryCode string
 	Timezone    string
+	ASN         uint   // autonomous system number, 0 if unknown
+	ASNOrg      string // the organisation the AS belongs to
+	Region      string // ISO 3166-2 subdivision, e.g. "GB-SCT", if known
 }
 
 var ErrUnknown = errors.New("unknown IP Address")
@@ -18,3 +21,13 @@ type Geolocater interface {
 	// main lookup
 	Geolocate(ip net.IP) (*LookupResult, error)
 }
+
+// Generation is how many times the locator's data has changed, for those
+// that can change (like a FileLocator), so cached results can be dropped
+// when it does. It is always 0 for those that can't.
+func Generation(l Geolocater) uint64 {
+	if g, ok := l.(interface{ Generation() uint64 }); ok {
+		return g.Generation()
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package geoip
+
+import (
+	"net"
+	"sort"
+)
+
+// InternalPrefix starts the pseudo country code given to internal
+// addresses, e.g. "internal:private", so they can be told apart from
+// addresses we know nothing about.
+const InternalPrefix = "internal:"
+
+const (
+	InternalPrivate   = InternalPrefix + "private"    // RFC 1918 and unique local addresses
+	InternalLoopback  = InternalPrefix + "loopback"   // 127.0.0.0/8 and ::1
+	InternalLinkLocal = InternalPrefix + "link-local" // 169.254.0.0/16 and fe80::/10
+	InternalCGNAT     = InternalPrefix + "cgnat"      // RFC 6598 shared address space
+)
+
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// IsInternal is whether a country code is one of the internal labels.
+func IsInternal(code string) bool {
+	return len(code) > len(InternalPrefix) && code[:len(InternalPrefix)] == InternalPrefix
+}
+
+// An InternalNetwork is one of our own networks, labelled as "internal:<Label>".
+type InternalNetwork struct {
+	Label string
+	Net   *net.IPNet
+}
+
+// Internal labels internal addresses, passing the rest on to next. The
+// networks given are checked first, the most specific winning, then the
+// private, loopback, link-local and CGNAT ranges.
+func Internal(next Geolocater, nets ...InternalNetwork) Geolocater {
+	nets = append([]InternalNetwork(nil), nets...)
+	sort.SliceStable(nets, func(i, j int) bool {
+		a, _ := nets[i].Net.Mask.Size()
+		b, _ := nets[j].Net.Mask.Size()
+		return a > b
+	})
+	return &internal{next: next, nets: nets}
+}
+
+type internal struct {
+	next Geolocater
+	nets []InternalNetwork
+}
+
+func (in *internal) Geolocate(ip net.IP) (*LookupResult, error) {
+	if label := in.label(ip); label != "" {
+		return &LookupResult{CountryCode: label}, nil
+	}
+	return in.next.Geolocate(ip)
+}
+
+func (in *internal) Generation() uint64 {
+	return Generation(in.next)
+}
+
+func (in *internal) label(ip net.IP) string {
+	for _, n := range in.nets {
+		if n.Net.Contains(ip) {
+			return InternalPrefix + n.Label
+		}
+	}
+	switch {
+	case ip.IsLoopback():
+		return InternalLoopback
+	case ip.IsPrivate():
+		return InternalPrivate
+	case ip.IsLinkLocalUnicast():
+		return InternalLinkLocal
+	case cgnat.Contains(ip):
+		return InternalCGNAT
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package geoip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInternal(t *testing.T) {
+	_, office, _ := net.ParseCIDR("10.1.0.0/16")
+	_, vpn, _ := net.ParseCIDR("10.0.0.0/8")
+	_, lab, _ := net.ParseCIDR("198.51.100.0/24")
+	l := Internal(&staticLocator{res: &LookupResult{CountryCode: "GB"}},
+		InternalNetwork{Label: "vpn", Net: vpn},
+		InternalNetwork{Label: "office", Net: office},
+		InternalNetwork{Label: "lab", Net: lab},
+	)
+	cases := []struct {
+		ip, expected string
+	}{
+		{"10.1.2.3", "internal:office"},
+		{"10.2.0.1", "internal:vpn"},
+		{"198.51.100.7", "internal:lab"},
+		{"192.168.1.1", InternalPrivate},
+		{"172.16.0.1", InternalPrivate},
+		{"fd00::1", InternalPrivate},
+		{"127.0.0.1", InternalLoopback},
+		{"::1", InternalLoopback},
+		{"169.254.1.1", InternalLinkLocal},
+		{"fe80::1", InternalLinkLocal},
+		{"100.64.0.1", InternalCGNAT},
+		{"100.128.0.1", "GB"},
+		{"203.0.113.1", "GB"},
+	}
+	for _, c := range cases {
+		res, err := l.Geolocate(net.ParseIP(c.ip))
+		if err != nil {
+			t.Errorf("%s: %v", c.ip, err)
+			continue
+		}
+		if res.CountryCode != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.ip, c.expected, res.CountryCode)
+		}
+	}
+	if !IsInternal("internal:office") || IsInternal("GB") || IsInternal(InternalPrefix) {
+		t.Error("IsInternal is wrong")
+	}
+}
@@ -1,44 +1,95 @@
 package geoip
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 
 	"github.com/oschwald/maxminddb-golang"
-
-	_ "embed"
 )
 
-var defaultLocator Geolocater = &MaxMind{}
-
+// what MustGeolocateWith gives when the country or timezone aren't known.
 const (
-	defaultCountryCode = "XX" // user-assigned code element
-	defaultTimezone    = "Etc/UTC"
+	DefaultCountryCode = "XX" // user-assigned code element
+	DefaultTimezone    = "Etc/UTC"
+)
+
+var (
+	defaultMu      sync.RWMutex
+	defaultLocator Geolocater
 )
 
+// SetDefault sets the locator used by Geolocate and MustGeolocate.
+func SetDefault(l Geolocater) {
+	defaultMu.Lock()
+	defaultLocator = l
+	defaultMu.Unlock()
+}
+
+// Default is the locator set with SetDefault, or Fallback if none was set.
+func Default() Geolocater {
+	defaultMu.RLock()
+	l := defaultLocator
+	defaultMu.RUnlock()
+	if l != nil {
+		return l
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultLocator == nil {
+		defaultLocator = Fallback()
+	}
+	return defaultLocator
+}
+
+// Geolocate looks the address up in the Default locator.
+//
+// Deprecated: pass a Geolocater around and call its Geolocate method.
 func Geolocate(ip net.IP) (*LookupResult, error) {
-	return defaultLocator.Geolocate(ip)
+	return Default().Geolocate(ip)
 }
 
+// MustGeolocate looks the address up in the Default locator.
+//
+// Deprecated: use MustGeolocateWith.
 func MustGeolocate(ip net.IP) *LookupResult {
-	r, err := Geolocate(ip)
+	return MustGeolocateWith(Default(), ip)
+}
+
+// MustGeolocateWith looks up the address, always returning a country and
+// timezone, defaulting to "XX" and "Etc/UTC" when they aren't known.
+func MustGeolocateWith(l Geolocater, ip net.IP) *LookupResult {
+	r, err := l.Geolocate(ip)
 	if err != nil {
 		return &LookupResult{
-			CountryCode: defaultCountryCode,
-			Timezone:    defaultTimezone,
+			CountryCode: DefaultCountryCode,
+			Timezone:    DefaultTimezone,
 		}
 	}
 	if r.CountryCode == "" {
-		r.CountryCode = defaultCountryCode
+		r.CountryCode = DefaultCountryCode
 	}
 	if r.Timezone == "" {
-		r.Timezone = defaultTimezone
+		r.Timezone = DefaultTimezone
 	}
 	return r
 }
 
-type MaxMind struct{}
+// MaxMind looks up a MaxMind format city or ASN database (or one with
+// both). The zero value uses the embedded database.
+type MaxMind struct {
+	reader *maxminddb.Reader
+}
+
+// NewMaxMind loads a database from its contents.
+func NewMaxMind(data []byte) (*MaxMind, error) {
+	r, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading maxmind db: %w", err)
+	}
+	return &MaxMind{reader: r}, nil
+}
 
 type mmResult struct {
 	Country struct {
@@ -47,36 +98,138 @@ type mmResult struct {
 	Location struct {
 		Timezone string `maxminddb:"time_zone"`
 	} `maxminddb:"location"`
+	// largest first, we only want the first
+	Subdivisions []struct {
+		Code string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	ASN    uint   `maxminddb:"autonomous_system_number"`
+	ASNOrg string `maxminddb:"autonomous_system_organization"`
 }
 
 func (mm *MaxMind) Geolocate(ip net.IP) (*LookupResult, error) {
-	mmInit()
+	r := mm.reader
+	if r == nil {
+		var err error
+		if r, err = embeddedReader(); err != nil {
+			return nil, err
+		}
+	}
 	var cityRes mmResult
-	cityErr := citys.Lookup(ip, &cityRes)
-	// if either failed, then we should return an error
+	_, ok, cityErr := r.LookupNetwork(ip, &cityRes)
 	if cityErr != nil {
 		return nil, fmt.Errorf("geolocate err: %w", cityErr)
 	}
-	return &LookupResult{
+	if !ok {
+		return nil, ErrUnknown
+	}
+	res := &LookupResult{
 		CountryCode: cityRes.Country.Code,
 		Timezone:    cityRes.Location.Timezone,
-	}, nil
+		ASN:         cityRes.ASN,
+		ASNOrg:      cityRes.ASNOrg,
+	}
+	if len(cityRes.Subdivisions) > 0 && cityRes.Subdivisions[0].Code != "" && res.CountryCode != "" {
+		res.Region = res.CountryCode + "-" + cityRes.Subdivisions[0].Code
+	}
+	return res, nil
 }
 
-// we generate the data we need from a MaxMind GeoLite2 databases
-// Of course we will need to embed the data
-//go:embed maxmind-geolite2-city.mmdb
+// the embedded database, see embed.go. It is empty when built with
+// the noembed tag.
 var cityData []byte
-var citys *maxminddb.Reader
 
-var mmInitOnce = sync.Once{}
+var ErrNoEmbeddedDB = errors.New("no embedded geoip database")
 
-func mmInit() {
+var (
+	mmInitOnce sync.Once
+	citys      *maxminddb.Reader
+	citysErr   error
+)
+
+func embeddedReader() (*maxminddb.Reader, error) {
 	mmInitOnce.Do(func() {
-		var err error
-		citys, err = maxminddb.FromBytes(cityData)
-		if err != nil {
-			panic("Error loading maxmind city DB: " + err.Error())
+		if len(cityData) == 0 {
+			citysErr = ErrNoEmbeddedDB
+			return
+		}
+		citys, citysErr = maxminddb.FromBytes(cityData)
+		if citysErr != nil {
+			citysErr = fmt.Errorf("error loading embedded maxmind city db: %w", citysErr)
 		}
 	})
+	return citys, citysErr
+}
+
+// Embedded is the embedded database, if there is a usable one.
+func Embedded() (Geolocater, error) {
+	if _, err := embeddedReader(); err != nil {
+		return nil, err
+	}
+	return &MaxMind{}, nil
+}
+
+// Merge looks up each locator in turn, using the first value found for
+// each field, e.g. a city database and an ASN database.
+func Merge(locators ...Geolocater) Geolocater {
+	return merged(locators)
+}
+
+type merged []Geolocater
+
+func (m merged) Geolocate(ip net.IP) (*LookupResult, error) {
+	var res *LookupResult
+	var firstErr error
+	for _, l := range m {
+		r, err := l.Geolocate(ip)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if res == nil {
+			res = r
+			continue
+		}
+		if res.CountryCode == "" {
+			res.CountryCode = r.CountryCode
+		}
+		if res.Timezone == "" {
+			res.Timezone = r.Timezone
+		}
+		if res.Region == "" {
+			res.Region = r.Region
+		}
+		if res.ASN == 0 {
+			res.ASN, res.ASNOrg = r.ASN, r.ASNOrg
+		}
+	}
+	if res == nil {
+		return nil, firstErr
+	}
+	return res, nil
+}
+
+// the generations only ever go up, so their sum changes when any does.
+func (m merged) Generation() uint64 {
+	var gen uint64
+	for _, l := range m {
+		gen += Generation(l)
+	}
+	return gen
+}
+
+// Noop knows nothing about any address.
+type Noop struct{}
+
+func (Noop) Geolocate(ip net.IP) (*LookupResult, error) {
+	return nil, ErrUnknown
+}
+
+// Fallback is the embedded database, or Noop without one.
+func Fallback() Geolocater {
+	if l, err := Embedded(); err == nil {
+		return l
+	}
+	return Noop{}
 }
@@ -1,18 +1,53 @@
 package geoip
 
 import (
-	"fmt"
 	"net"
 	"testing"
 )
 
-func TestMaxmind(*testing.T) {
-	ip := net.ParseIP("2001:41d0:a:218f::1")
-	//ip := net.ParseIP("37.187.97.143")
-	//ip := net.ParseIP("212.69.49.161")
-	mm := MaxMind{}
-	res, err := mm.Geolocate(ip)
+type staticLocator struct {
+	res *LookupResult
+	err error
+}
+
+func (s *staticLocator) Geolocate(ip net.IP) (*LookupResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	r := *s.res
+	return &r, nil
+}
+
+func TestMerge(t *testing.T) {
+	city := &staticLocator{res: &LookupResult{CountryCode: "GB", Timezone: "Europe/London", Region: "GB-ENG"}}
+	asn := &staticLocator{res: &LookupResult{ASN: 2856, ASNOrg: "British Telecommunications PLC"}}
+	res, err := Merge(city, asn).Geolocate(net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CountryCode != "GB" || res.Timezone != "Europe/London" || res.Region != "GB-ENG" || res.ASN != 2856 || res.ASNOrg != "British Telecommunications PLC" {
+		t.Errorf("unexpected merged result %#v", res)
+	}
+	res, err = Merge(&staticLocator{err: ErrUnknown}, asn).Geolocate(net.ParseIP("192.0.2.1"))
+	if err != nil || res.ASN != 2856 {
+		t.Errorf("expected the ASN even when the city lookup fails, got %#v, %v", res, err)
+	}
+	if _, err := Merge(Noop{}, Noop{}).Geolocate(net.ParseIP("192.0.2.1")); err != ErrUnknown {
+		t.Errorf("expected ErrUnknown when nothing is found, got %v", err)
+	}
+}
 
-	fmt.Printf("err: %s\n", err)
-	fmt.Printf("res: %#v\n", res)
+func TestDefault(t *testing.T) {
+	defer SetDefault(nil)
+	SetDefault(&staticLocator{res: &LookupResult{CountryCode: "GB"}})
+	if res, err := Geolocate(net.ParseIP("192.0.2.1")); err != nil || res.CountryCode != "GB" {
+		t.Errorf("expected the default locator to be used, got %#v, %v", res, err)
+	}
+	if res := MustGeolocate(net.ParseIP("192.0.2.1")); res.CountryCode != "GB" || res.Timezone != DefaultTimezone {
+		t.Errorf("expected GB with the default timezone, got %#v", res)
+	}
+	SetDefault(Noop{})
+	if res := MustGeolocate(net.ParseIP("192.0.2.1")); res.CountryCode != DefaultCountryCode || res.Timezone != DefaultTimezone {
+		t.Errorf("expected the defaults when unknown, got %#v", res)
+	}
 }
@@ -0,0 +1,249 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// a range of addresses, always in their 16 byte form.
+type ipRange struct {
+	start, end net.IP
+	res        LookupResult
+}
+
+// RangeTable looks addresses up in a list of ranges, the most specific
+// range containing the address wins.
+type RangeTable struct {
+	ranges []ipRange
+}
+
+func (rt *RangeTable) add(start, end net.IP, res LookupResult) {
+	rt.ranges = append(rt.ranges, ipRange{start: start.To16(), end: end.To16(), res: res})
+}
+
+// sorts the ranges and flattens them, so they don't overlap and a lookup
+// only needs to check one range.
+func (rt *RangeTable) build() {
+	// by start, and larger ranges first, so the more specific of
+	// overlapping ranges comes later.
+	sort.SliceStable(rt.ranges, func(i, j int) bool {
+		a, b := rt.ranges[i], rt.ranges[j]
+		if c := bytes.Compare(a.start, b.start); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(a.end, b.end) > 0
+	})
+	rt.ranges = flatten(rt.ranges)
+}
+
+// flatten turns sorted, possibly overlapping, ranges into disjoint ones. The
+// open ranges are kept in a stack, the top one still open being the most
+// specific, so it covers everything up to its end or the next range start.
+func flatten(ranges []ipRange) []ipRange {
+	var flat, open []ipRange
+	var cursor net.IP
+	// covers up to (but not including) the given address with the open
+	// ranges, closing those that end before it. a nil address means the end
+	// of the address space.
+	closeUntil := func(until net.IP) {
+		for len(open) > 0 && cursor != nil {
+			top := open[len(open)-1]
+			if until != nil && bytes.Compare(top.end, until) >= 0 {
+				if bytes.Compare(cursor, until) < 0 {
+					flat = append(flat, ipRange{start: cursor, end: prevIP(until), res: top.res})
+				}
+				return
+			}
+			open = open[:len(open)-1]
+			if bytes.Compare(cursor, top.end) <= 0 {
+				flat = append(flat, ipRange{start: cursor, end: top.end, res: top.res})
+				cursor = nextIP(top.end)
+			}
+		}
+	}
+	for _, r := range ranges {
+		closeUntil(r.start)
+		open = append(open, r)
+		cursor = r.start
+	}
+	closeUntil(nil)
+	return flat
+}
+
+// the address after ip, or nil if it is the last one.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// the address before ip, which must not be the first one.
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+func (rt *RangeTable) Geolocate(ip net.IP) (*LookupResult, error) {
+	ip = ip.To16()
+	if ip == nil {
+		return nil, ErrUnknown
+	}
+	// the first range starting after the ip, so the one before is the only
+	// one that can contain it.
+	i := sort.Search(len(rt.ranges), func(i int) bool {
+		return bytes.Compare(rt.ranges[i].start, ip) > 0
+	})
+	if i > 0 {
+		if r := rt.ranges[i-1]; bytes.Compare(ip, r.end) <= 0 {
+			res := r.res
+			return &res, nil
+		}
+	}
+	return nil, ErrUnknown
+}
+
+// OpenCIDR loads a CSV of `network,country[,timezone[,region]]`, where the
+// network is a CIDR or a single address. Blank lines and lines starting
+// with # are ignored.
+func OpenCIDR(path string) (Geolocater, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cidr table: %w", err)
+	}
+	defer f.Close()
+	rt := &RangeTable{}
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read cidr table: %w", err)
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("cidr table line %q should have a network and a country", strings.Join(rec, ","))
+		}
+		start, end, err := parseNetwork(rec[0])
+		if err != nil {
+			return nil, err
+		}
+		res := LookupResult{CountryCode: strings.ToUpper(rec[1])}
+		if len(rec) > 2 {
+			res.Timezone = rec[2]
+		}
+		if len(rec) > 3 {
+			res.Region = rec[3]
+		}
+		rt.add(start, end, res)
+	}
+	rt.build()
+	return rt, nil
+}
+
+// the first and last address of the network
+func parseNetwork(s string) (net.IP, net.IP, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("bad address %q", s)
+		}
+		return ip, ip, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad network %q: %w", s, err)
+	}
+	end := make(net.IP, len(n.IP))
+	for i := range n.IP {
+		end[i] = n.IP[i] | ^n.Mask[i]
+	}
+	return n.IP, end, nil
+}
+
+// OpenIP2Location loads an IP2Location LITE CSV (DB1 or above, IPv4 or
+// IPv6): `"ip_from","ip_to","country_code","country_name",...`. Only the
+// country is used, as the regions are names rather than codes, and the
+// timezones are offsets.
+func OpenIP2Location(path string) (Geolocater, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ip2location db: %w", err)
+	}
+	defer f.Close()
+	rt := &RangeTable{}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read ip2location db: %w", err)
+		}
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("ip2location db line should have at least 3 fields")
+		}
+		start, err := parseIPNumber(rec[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseIPNumber(rec[1])
+		if err != nil {
+			return nil, err
+		}
+		country := rec[2]
+		if country == "-" {
+			// not allocated, so not worth keeping
+			continue
+		}
+		rt.ranges = append(rt.ranges, ipRange{start: start, end: end, res: LookupResult{CountryCode: country}})
+	}
+	// they should be sorted already, but make sure.
+	rt.build()
+	return rt, nil
+}
+
+var maxIPv4 = big.NewInt(1<<32 - 1)
+
+// addresses are decimal numbers, IPv4 ones fit in 32 bits. In the IPv6
+// files IPv4 addresses are already mapped to ::ffff:0:0/96.
+func parseIPNumber(s string) (net.IP, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return nil, fmt.Errorf("bad ip2location address %q", s)
+	}
+	if n.Cmp(maxIPv4) <= 0 {
+		b := make([]byte, 4)
+		n.FillBytes(b)
+		return net.IP(b).To16(), nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	n.FillBytes(ip)
+	return ip, nil
+}
@@ -0,0 +1,211 @@
+package geoip
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0x6377/hindsight/internal/lru"
+)
+
+func writeTemp(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCIDRTable(t *testing.T) {
+	path := writeTemp(t, "networks.csv", `# network,country,timezone,region
+10.0.0.0/8,gb,Europe/London
+10.1.0.0/16,FR,Europe/Paris,FR-IDF
+192.0.2.1,US
+2001:db8::/32,DE,Europe/Berlin
+`)
+	l, err := Open("cidr", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ip, country, tz, region string
+	}{
+		{"10.0.0.1", "GB", "Europe/London", ""},
+		{"10.1.2.3", "FR", "Europe/Paris", "FR-IDF"},
+		{"10.2.0.0", "GB", "Europe/London", ""},
+		{"10.255.255.255", "GB", "Europe/London", ""},
+		{"192.0.2.1", "US", "", ""},
+		{"2001:db8::1", "DE", "Europe/Berlin", ""},
+		{"192.0.2.2", "", "", ""},
+		{"11.0.0.0", "", "", ""},
+	}
+	for _, c := range cases {
+		res, err := l.Geolocate(net.ParseIP(c.ip))
+		if c.country == "" {
+			if err != ErrUnknown {
+				t.Errorf("%s: expected unknown, got %v %v", c.ip, res, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.ip, err)
+			continue
+		}
+		if res.CountryCode != c.country || res.Timezone != c.tz || res.Region != c.region {
+			t.Errorf("%s: expected %s %s %s, got %+v", c.ip, c.country, c.tz, c.region, res)
+		}
+	}
+}
+
+func TestRangeTableOverlapping(t *testing.T) {
+	rt := &RangeTable{}
+	add := func(start, end, country string) {
+		rt.add(net.ParseIP(start), net.ParseIP(end), LookupResult{CountryCode: country})
+	}
+	add("::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "ALL")
+	add("10.0.0.0", "10.255.255.255", "A")
+	add("10.1.0.0", "10.1.255.255", "B")
+	add("10.1.2.0", "10.1.2.255", "C")
+	add("10.1.2.0", "10.1.2.0", "D")
+	add("10.200.0.0", "11.0.0.255", "E") // overlaps the end of A
+	rt.build()
+	for i := 1; i < len(rt.ranges); i++ {
+		if prev := rt.ranges[i-1]; !nextIP(prev.end).Equal(rt.ranges[i].start) {
+			t.Errorf("ranges %d and %d are not adjacent: %v-%v, %v-%v", i-1, i, prev.start, prev.end, rt.ranges[i].start, rt.ranges[i].end)
+		}
+	}
+	cases := []struct {
+		ip, country string
+	}{
+		{"::1", "ALL"},
+		{"9.255.255.255", "ALL"},
+		{"10.0.0.0", "A"},
+		{"10.1.0.0", "B"},
+		{"10.1.2.0", "D"},
+		{"10.1.2.1", "C"},
+		{"10.1.2.255", "C"},
+		{"10.1.3.0", "B"},
+		{"10.2.0.0", "A"},
+		{"10.199.255.255", "A"},
+		{"10.200.0.0", "E"},
+		{"11.0.0.255", "E"},
+		{"11.0.1.0", "ALL"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "ALL"},
+	}
+	for _, c := range cases {
+		res, err := rt.Geolocate(net.ParseIP(c.ip))
+		if err != nil {
+			t.Errorf("%s: %v", c.ip, err)
+			continue
+		}
+		if res.CountryCode != c.country {
+			t.Errorf("%s: expected %s, got %s", c.ip, c.country, res.CountryCode)
+		}
+	}
+}
+
+func TestIP2Location(t *testing.T) {
+	// an IPv4 DB1 file, and an IPv6 one with IPv4 mapped addresses
+	v4 := writeTemp(t, "ip2location.csv", `"0","16777215","-","-"
+"16777216","16777471","AU","Australia"
+"3221225984","3221226239","US","United States of America"
+`)
+	v6 := writeTemp(t, "ip2location6.csv", `"281470698520576","281470698520831","AU","Australia"
+"42540766411282592856903984951653826560","42540766490510755371168322545197776895","NL","Netherlands"
+`)
+	cases := []struct {
+		path, ip, country string
+	}{
+		{v4, "1.0.0.1", "AU"},
+		{v4, "192.0.2.99", "US"},
+		{v4, "0.0.0.1", ""},
+		{v4, "1.0.1.0", ""},
+		{v6, "1.0.0.255", "AU"},
+		{v6, "2001:db8::1", "NL"},
+		{v6, "2001:db9::1", ""},
+	}
+	for _, c := range cases {
+		l, err := Open("ip2location", c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := l.Geolocate(net.ParseIP(c.ip))
+		if c.country == "" {
+			if err != ErrUnknown {
+				t.Errorf("%s: expected unknown, got %v %v", c.ip, res, err)
+			}
+			continue
+		}
+		if err != nil || res.CountryCode != c.country {
+			t.Errorf("%s: expected %s, got %v %v", c.ip, c.country, res, err)
+		}
+	}
+}
+
+func TestOpenUnknownKind(t *testing.T) {
+	if _, err := Open("nope", "x"); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
+
+// the cost of a lookup in each kind of database, and in a cache of the
+// results, as the geoip processor uses.
+func BenchmarkGeolocate(b *testing.B) {
+	var cidrs, ranges strings.Builder
+	for i := 0; i < 1<<16; i++ {
+		fmt.Fprintf(&cidrs, "%d.%d.0.0/16,GB\n", i>>8, i&0xff)
+		fmt.Fprintf(&ranges, "\"%d\",\"%d\",\"GB\",\"United Kingdom\"\n", i<<16, i<<16|0xffff)
+	}
+	dir := b.TempDir()
+	locators := map[string]Geolocater{}
+	for kind, data := range map[string]string{"cidr": cidrs.String(), "ip2location": ranges.String()} {
+		path := filepath.Join(dir, kind+".csv")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			b.Fatal(err)
+		}
+		l, err := Open(kind, path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		locators[kind] = l
+	}
+	if l, err := Embedded(); err == nil {
+		locators["embedded"] = l
+	}
+	// many more addresses than the cache holds, with a few frequent visitors
+	// and a long tail, as on a real site.
+	const distinct, cacheSize = 1 << 16, 1024
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, distinct-1)
+	ips := make([]net.IP, 1<<16)
+	for i := range ips {
+		n := zipf.Uint64()
+		ips[i] = net.IPv4(byte(n>>8), byte(n), 113, 1)
+	}
+	for _, kind := range []string{"cidr", "ip2location", "embedded"} {
+		l, ok := locators[kind]
+		if !ok {
+			continue
+		}
+		b.Run(kind, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				MustGeolocateWith(l, ips[i%len(ips)])
+			}
+		})
+	}
+	b.Run("cached", func(b *testing.B) {
+		cache := lru.New(cacheSize)
+		misses := 0
+		for i := 0; i < b.N; i++ {
+			key := ips[i%len(ips)].String()
+			if _, ok := cache.Get(key); !ok {
+				misses++
+				cache.Add(key, MustGeolocateWith(locators["cidr"], ips[i%len(ips)]))
+			}
+		}
+		b.ReportMetric(float64(b.N-misses)/float64(b.N), "hits/op")
+	})
+}
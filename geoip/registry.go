@@ -0,0 +1,68 @@
+package geoip
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// A Factory opens a locator from its data file.
+type Factory func(path string) (Geolocater, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a kind of locator available to Open. It panics if the
+// name is already taken.
+func Register(kind string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[kind]; ok {
+		panic("geoip: locator registered twice: " + kind)
+	}
+	registry[kind] = f
+}
+
+// Open loads a locator of the kind from the path.
+func Open(kind, path string) (Geolocater, error) {
+	registryMu.RLock()
+	f, ok := registry[kind]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown geoip locator %q", kind)
+	}
+	return f(path)
+}
+
+// Kinds lists the registered kinds of locator.
+func Kinds() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	kinds := make([]string, 0, len(registry))
+	for k := range registry {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func openMMDB(path string) (Geolocater, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read geoip db: %w", err)
+	}
+	return NewMaxMind(data)
+}
+
+// the built-in locators
+func init() {
+	// MaxMind GeoLite2/GeoIP2 city, country or ASN databases
+	Register("maxmind", openMMDB)
+	// DB-IP lite databases use the same format and fields
+	Register("dbip", openMMDB)
+	Register("ip2location", OpenIP2Location)
+	Register("cidr", OpenCIDR)
+}
@@ -0,0 +1,98 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// StripOptions are what Strip keeps, beyond the country and timezone.
+type StripOptions struct {
+	Regions bool // the first subdivision, for the regions report
+	ASN     bool // the network number and organisation
+}
+
+// ErrNothingKept is returned by Strip when no network had any of the fields
+// to keep, e.g. stripping an unknown kind of database.
+var ErrNothingKept = errors.New("no networks left after stripping")
+
+// StripStats describe the stripped database.
+type StripStats struct {
+	Networks int // in the source database
+	Kept     int // networks with a value we kept
+}
+
+// Strip rewrites a MaxMind format database, keeping only the fields we
+// look up. Networks left with nothing are dropped, and adjacent networks
+// left with the same values are merged, so the result is much smaller. The
+// ASN is always kept from an ASN database, as there is nothing else in it.
+func Strip(src []byte, opts StripOptions) ([]byte, *StripStats, error) {
+	r, err := maxminddb.FromBytes(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading maxmind db: %w", err)
+	}
+	if strings.Contains(strings.ToUpper(r.Metadata.DatabaseType), "ASN") {
+		opts.ASN = true
+	}
+	w, err := NewWriter(r.Metadata.DatabaseType, int(r.Metadata.IPVersion))
+	if err != nil {
+		return nil, nil, err
+	}
+	w.BuildEpoch = uint64(r.Metadata.BuildEpoch)
+	w.Description = fmt.Sprintf("%s, stripped by hindsight", r.Metadata.DatabaseType)
+	stats := &StripStats{}
+	networks := r.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var res mmResult
+		network, err := networks.Network(&res)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading maxmind db: %w", err)
+		}
+		stats.Networks++
+		value := strippedValue(&res, opts)
+		if len(value) == 0 {
+			continue
+		}
+		if err := w.Insert(network, value); err != nil {
+			return nil, nil, err
+		}
+		stats.Kept++
+	}
+	if err := networks.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading maxmind db: %w", err)
+	}
+	if stats.Kept == 0 {
+		return nil, stats, fmt.Errorf("%w of %d in the %q database", ErrNothingKept, stats.Networks, r.Metadata.DatabaseType)
+	}
+	var out bytes.Buffer
+	if _, err := w.WriteTo(&out); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), stats, nil
+}
+
+// the fields of res we want, in the same shape as MaxMind's.
+func strippedValue(res *mmResult, opts StripOptions) map[string]interface{} {
+	value := map[string]interface{}{}
+	if res.Country.Code != "" {
+		value["country"] = map[string]interface{}{"iso_code": res.Country.Code}
+	}
+	if res.Location.Timezone != "" {
+		value["location"] = map[string]interface{}{"time_zone": res.Location.Timezone}
+	}
+	if opts.Regions && len(res.Subdivisions) > 0 && res.Subdivisions[0].Code != "" {
+		value["subdivisions"] = []interface{}{
+			map[string]interface{}{"iso_code": res.Subdivisions[0].Code},
+		}
+	}
+	if opts.ASN && res.ASN != 0 {
+		value["autonomous_system_number"] = uint32(res.ASN)
+		if res.ASNOrg != "" {
+			value["autonomous_system_organization"] = res.ASNOrg
+		}
+	}
+	return value
+}
@@ -0,0 +1,205 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDownloadURL is MaxMind's download, {edition}, {license_key} and
+// {suffix} are replaced. The suffix is "tar.gz" for the database, and
+// "tar.gz.sha256" for its checksum.
+const DefaultDownloadURL = "https://download.maxmind.com/app/geoip_download?edition_id={edition}&license_key={license_key}&suffix={suffix}"
+
+const (
+	DefaultEdition    = "GeoLite2-City"
+	DefaultASNEdition = "GeoLite2-ASN"
+)
+
+// Updater downloads a database, checks it, and installs it.
+type Updater struct {
+	URL        string // see DefaultDownloadURL
+	Edition    string
+	LicenseKey string
+	Client     *http.Client
+	// Verify checks the downloaded database before it is installed, by
+	// default that it loads as a MaxMind database.
+	Verify func(path string) error
+}
+
+var ErrChecksumMismatch = errors.New("geoip download checksum mismatch")
+
+func (u *Updater) url(suffix string) string {
+	tmpl := u.URL
+	if tmpl == "" {
+		tmpl = DefaultDownloadURL
+	}
+	edition := u.Edition
+	if edition == "" {
+		edition = DefaultEdition
+	}
+	return strings.NewReplacer("{edition}", edition, "{license_key}", u.LicenseKey, "{suffix}", suffix).Replace(tmpl)
+}
+
+func (u *Updater) get(ctx context.Context, location string) (*http.Response, error) {
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		// the url has the license key in it, so leave it out
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("geoip download failed: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("geoip download failed: %s", res.Status)
+	}
+	return res, nil
+}
+
+// the checksum file is `<hex sha256>  <filename>`
+func (u *Updater) checksum(ctx context.Context) (string, error) {
+	res, err := u.get(ctx, u.url("tar.gz.sha256"))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("geoip checksum download failed: %w", err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("geoip checksum is empty")
+	}
+	sum := strings.ToLower(fields[0])
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("geoip checksum is not a sha256: %q", fields[0])
+	}
+	return sum, nil
+}
+
+// the installed database's checksum is kept next to it, so we know when
+// there is nothing new to download.
+func checksumPath(dest string) string {
+	return dest + ".sha256"
+}
+
+// Update downloads the database and installs it at dest, replacing it
+// atomically, unless the installed one is already the latest (and not force).
+// It returns whether a new database was installed.
+func (u *Updater) Update(ctx context.Context, dest string, force bool) (bool, error) {
+	sum, err := u.checksum(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !force {
+		if installed, err := os.ReadFile(checksumPath(dest)); err == nil && strings.TrimSpace(string(installed)) == sum {
+			if _, err := os.Stat(dest); err == nil {
+				return false, nil
+			}
+		}
+	}
+	res, err := u.get(ctx, u.url("tar.gz"))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	// the archive is checked before we extract anything from it.
+	var archive bytes.Buffer
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(&archive, h), res.Body); err != nil {
+		return false, fmt.Errorf("geoip download failed: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sum {
+		return false, ErrChecksumMismatch
+	}
+	// in the same directory, so the rename is atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".geoip-*.mmdb")
+	if err != nil {
+		return false, fmt.Errorf("could not create geoip db: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = extractMMDB(&archive, tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	verify := u.Verify
+	if verify == nil {
+		verify = verifyMaxMind
+	}
+	if err := verify(tmp.Name()); err != nil {
+		return false, fmt.Errorf("downloaded geoip db is bad: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return false, fmt.Errorf("could not install geoip db: %w", err)
+	}
+	if err := os.WriteFile(checksumPath(dest), []byte(sum+"\n"), 0o644); err != nil {
+		return true, fmt.Errorf("could not store geoip db checksum: %w", err)
+	}
+	return true, nil
+}
+
+// copies the first .mmdb file in the tar.gz to w.
+func extractMMDB(r io.Reader, w io.Writer) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("geoip download is not gzipped: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("no .mmdb file in geoip download")
+		}
+		if err != nil {
+			return fmt.Errorf("geoip download is not a tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".mmdb") {
+			continue
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return fmt.Errorf("could not extract geoip db: %w", err)
+		}
+		return nil
+	}
+}
+
+func verifyMaxMind(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = NewMaxMind(data)
+	return err
+}
@@ -0,0 +1,93 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testArchive(t *testing.T, db []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string][]byte{
+		"GeoLite2-City_20220104/LICENSE.txt":        []byte("license"),
+		"GeoLite2-City_20220104/GeoLite2-City.mmdb": db,
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestUpdater(t *testing.T) {
+	archive := testArchive(t, []byte("database"))
+	sum := sha256.Sum256(archive)
+	checksum := hex.EncodeToString(sum[:])
+	downloads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("license_key") != "secret" {
+			http.Error(rw, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Query().Get("suffix") {
+		case "tar.gz":
+			downloads++
+			rw.Write(archive)
+		case "tar.gz.sha256":
+			fmt.Fprintf(rw, "%s  GeoLite2-City_20220104.tar.gz\n", checksum)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "city.mmdb")
+	u := &Updater{
+		URL:        srv.URL + "/?edition_id={edition}&license_key={license_key}&suffix={suffix}",
+		LicenseKey: "secret",
+		Verify: func(path string) error {
+			if b, _ := os.ReadFile(path); string(b) != "database" {
+				return fmt.Errorf("unexpected content %q", b)
+			}
+			return nil
+		},
+	}
+	ctx := context.Background()
+	if installed, err := u.Update(ctx, dest, false); err != nil || !installed {
+		t.Fatalf("expected the database installed, got %v, %v", installed, err)
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "database" {
+		t.Errorf("expected the database at %s, got %q", dest, b)
+	}
+	if installed, err := u.Update(ctx, dest, false); err != nil || installed || downloads != 1 {
+		t.Errorf("expected no download when up to date, got %v, %v after %d downloads", installed, err, downloads)
+	}
+
+	// a bad checksum must leave the installed database alone
+	checksum = hex.EncodeToString(make([]byte, sha256.Size))
+	if _, err := u.Update(ctx, dest, true); err != ErrChecksumMismatch {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "database" {
+		t.Errorf("expected the installed database untouched, got %q", b)
+	}
+
+	u.LicenseKey = "wrong"
+	if _, err := u.Update(ctx, dest, true); err == nil {
+		t.Error("expected an error with the wrong license key")
+	}
+}
@@ -0,0 +1,303 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+// the start of the metadata section
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Writer builds a MaxMind DB format database, as read by NewMaxMind.
+// Identical values are only stored once, and adjacent networks with the
+// same value are merged.
+type Writer struct {
+	DatabaseType string // e.g. "GeoLite2-City"
+	Description  string
+	BuildEpoch   uint64 // seconds since the epoch, now if zero
+
+	ipVersion int
+	root      *trieNode
+	data      bytes.Buffer
+	offsets   map[string]int // encoded value -> offset in data
+}
+
+// a node in the search tree. Leaves have no children, and the offset
+// of their value in the data section plus one, or 0 for no value.
+type trieNode struct {
+	children [2]*trieNode
+	value    int
+}
+
+func (n *trieNode) leaf() bool {
+	return n.children[0] == nil
+}
+
+// NewWriter creates a writer for an IPv4 (4) or IPv6 (6) database. IPv4
+// networks in an IPv6 database are stored in ::/96.
+func NewWriter(dbType string, ipVersion int) (*Writer, error) {
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("bad ip version %d", ipVersion)
+	}
+	return &Writer{
+		DatabaseType: dbType,
+		ipVersion:    ipVersion,
+		root:         &trieNode{},
+		offsets:      map[string]int{},
+	}, nil
+}
+
+// Insert sets the value for the network, replacing the value of any network
+// within it. Values may be strings, bools, float64s, uint16s, uint32s,
+// uint64s, []interface{}s or map[string]interface{}s of them.
+func (w *Writer) Insert(network *net.IPNet, value map[string]interface{}) error {
+	ip, bits := network.IP, 0
+	ones, size := network.Mask.Size()
+	switch {
+	case ip.To4() != nil && size == 32:
+		ip = ip.To4()
+		if w.ipVersion == 6 {
+			ip = append(make(net.IP, 12), ip...)
+			ones += 96
+		}
+	case w.ipVersion == 6 && len(ip) == net.IPv6len && size == 128:
+	default:
+		return fmt.Errorf("network %s does not fit an IPv%d database", network, w.ipVersion)
+	}
+	var enc bytes.Buffer
+	if err := encode(&enc, value); err != nil {
+		return fmt.Errorf("network %s: %w", network, err)
+	}
+	offset, ok := w.offsets[enc.String()]
+	if !ok {
+		offset = w.data.Len()
+		w.offsets[enc.String()] = offset
+		w.data.Write(enc.Bytes())
+	}
+	n := w.root
+	for ; bits < ones; bits++ {
+		if n.leaf() {
+			// split, both halves keep the value of the whole
+			n.children = [2]*trieNode{{value: n.value}, {value: n.value}}
+			n.value = 0
+		}
+		n = n.children[(ip[bits/8]>>(7-bits%8))&1]
+	}
+	n.children = [2]*trieNode{}
+	n.value = offset + 1
+	return nil
+}
+
+// merges sibling leaves with the same value, from the bottom up.
+func merge(n *trieNode) {
+	if n.leaf() {
+		return
+	}
+	merge(n.children[0])
+	merge(n.children[1])
+	l, r := n.children[0], n.children[1]
+	if l.leaf() && r.leaf() && l.value == r.value {
+		n.children = [2]*trieNode{}
+		n.value = l.value
+	}
+}
+
+// WriteTo writes the database.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	merge(w.root)
+	root := w.root
+	if root.leaf() {
+		// the tree needs at least one node
+		root = &trieNode{children: [2]*trieNode{{value: root.value}, {value: root.value}}}
+	}
+	// number the nodes breadth first, the root is 0.
+	nodes := []*trieNode{root}
+	index := map[*trieNode]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].children {
+			if !c.leaf() {
+				index[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+	record := func(n *trieNode) uint64 {
+		switch {
+		case !n.leaf():
+			return uint64(index[n])
+		case n.value == 0:
+			return uint64(nodeCount)
+		default:
+			// after the 16 byte separator
+			return uint64(nodeCount + 16 + n.value - 1)
+		}
+	}
+	max := uint64(nodeCount + 16 + w.data.Len())
+	recordSize := 24
+	if max >= 1<<24 {
+		recordSize = 28
+	}
+	if max >= 1<<28 {
+		recordSize = 32
+	}
+	if max >= 1<<32 {
+		return 0, errors.New("database too large")
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(nodeCount*recordSize/4 + 16 + w.data.Len())
+	for _, n := range nodes {
+		l, r := record(n.children[0]), record(n.children[1])
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 28:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l),
+				byte(l>>24)<<4 | byte(r>>24)&0x0f,
+				byte(r >> 16), byte(r >> 8), byte(r)})
+		case 32:
+			var b [8]byte
+			binary.BigEndian.PutUint32(b[:4], uint32(l))
+			binary.BigEndian.PutUint32(b[4:], uint32(r))
+			buf.Write(b[:])
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(w.data.Bytes())
+
+	epoch := w.BuildEpoch
+	if epoch == 0 {
+		epoch = uint64(time.Now().Unix())
+	}
+	description := map[string]interface{}{}
+	if w.Description != "" {
+		description["en"] = w.Description
+	}
+	buf.Write(metadataMarker)
+	err := encode(&buf, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 epoch,
+		"database_type":               w.DatabaseType,
+		"description":                 description,
+		"ip_version":                  uint16(w.ipVersion),
+		"languages":                   []interface{}{},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(out)
+}
+
+// the data section types we write
+const (
+	typeString = 2
+	typeDouble = 3
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+	typeBool   = 14
+)
+
+func encode(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		writeControl(b, typeString, len(v))
+		b.WriteString(v)
+	case float64:
+		writeControl(b, typeDouble, 8)
+		var f [8]byte
+		binary.BigEndian.PutUint64(f[:], math.Float64bits(v))
+		b.Write(f[:])
+	case uint16:
+		writeUint(b, typeUint16, uint64(v))
+	case uint32:
+		writeUint(b, typeUint32, uint64(v))
+	case uint64:
+		writeUint(b, typeUint64, v)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		writeControl(b, typeBool, size)
+	case []interface{}:
+		writeControl(b, typeArray, len(v))
+		for _, e := range v {
+			if err := encode(b, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// sorted, so equal maps encode the same
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeControl(b, typeMap, len(v))
+		for _, k := range keys {
+			encode(b, k)
+			if err := encode(b, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %T in a maxmind db", v)
+	}
+	return nil
+}
+
+// unsigned integers are big endian, without leading zeros.
+func writeUint(b *bytes.Buffer, typ int, v uint64) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], v)
+	i := 0
+	for i < 8 && n[i] == 0 {
+		i++
+	}
+	writeControl(b, typ, 8-i)
+	b.Write(n[i:])
+}
+
+// the control byte has the type in the top 3 bits (0 for an extended type
+// in the next byte) and the size, or how to read it, in the bottom 5.
+func writeControl(b *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	if typ <= 7 {
+		ctrl = byte(typ << 5)
+	}
+	var ext []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		ext = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		s := size - 285
+		ext = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		ext = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	b.WriteByte(ctrl)
+	if typ > 7 {
+		b.WriteByte(byte(typ - 7))
+	}
+	b.Write(ext)
+}
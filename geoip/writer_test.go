@@ -0,0 +1,150 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func city(country, tz, region string, asn uint32) map[string]interface{} {
+	v := map[string]interface{}{
+		"country":    map[string]interface{}{"iso_code": country, "names": map[string]interface{}{"en": "Somewhere"}},
+		"location":   map[string]interface{}{"time_zone": tz, "latitude": 51.5, "accuracy_radius": uint16(100)},
+		"is_anycast": false,
+	}
+	if region != "" {
+		v["subdivisions"] = []interface{}{map[string]interface{}{"iso_code": region}}
+	}
+	if asn != 0 {
+		v["autonomous_system_number"] = asn
+		v["autonomous_system_organization"] = "Example Networks"
+	}
+	return v
+}
+
+func TestWriterAndStrip(t *testing.T) {
+	w, err := NewWriter("Test-City", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserts := []struct {
+		network string
+		value   map[string]interface{}
+	}{
+		{"192.0.2.0/25", city("GB", "Europe/London", "SCT", 64500)},
+		{"192.0.2.128/25", city("GB", "Europe/London", "SCT", 64500)},
+		{"198.51.100.0/24", city("FR", "Europe/Paris", "", 0)},
+		{"198.51.100.64/26", city("DE", "Europe/Berlin", "", 0)},
+		{"2001:db8::/32", city("NL", "Europe/Amsterdam", "", 64501)},
+	}
+	for _, in := range inserts {
+		if err := w.Insert(mustCIDR(t, in.network), in.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+	stripped, stats, err := Strip(full, StripOptions{Regions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the two halves of 192.0.2.0/24 were merged by the writer
+	if stats.Networks != 5 || stats.Kept != 5 {
+		t.Errorf("expected 5 networks kept, got %+v", stats)
+	}
+	if len(stripped) >= len(full) {
+		t.Errorf("expected the stripped db (%d bytes) to be smaller than the original (%d)", len(stripped), len(full))
+	}
+	cases := []struct {
+		ip                  string
+		country, tz, region string
+	}{
+		{"192.0.2.200", "GB", "Europe/London", "GB-SCT"},
+		{"198.51.100.1", "FR", "Europe/Paris", ""},
+		{"198.51.100.65", "DE", "Europe/Berlin", ""},
+		{"198.51.100.128", "FR", "Europe/Paris", ""},
+		{"2001:db8::1", "NL", "Europe/Amsterdam", ""},
+	}
+	mm, err := NewMaxMind(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		res, err := mm.Geolocate(net.ParseIP(c.ip))
+		if err != nil {
+			t.Errorf("%s: %v", c.ip, err)
+			continue
+		}
+		if res.CountryCode != c.country || res.Timezone != c.tz || res.Region != c.region || res.ASN != 0 {
+			t.Errorf("%s: expected %s %s %s, got %+v", c.ip, c.country, c.tz, c.region, res)
+		}
+	}
+	if _, err := mm.Geolocate(net.ParseIP("203.0.113.1")); err != ErrUnknown {
+		t.Errorf("expected an unknown address, got %v", err)
+	}
+	// the ASN is only kept when asked for
+	withASN, _, err := Strip(full, StripOptions{ASN: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm, err = NewMaxMind(withASN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := mm.Geolocate(net.ParseIP("2001:db8::1"))
+	if err != nil || res.ASN != 64501 || res.ASNOrg != "Example Networks" || res.Region != "" {
+		t.Errorf("expected the ASN and no region, got %+v %v", res, err)
+	}
+}
+
+func TestStripASN(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "asn.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an ASN database keeps its ASNs without asking
+	stripped, stats, err := Strip(src, StripOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Kept != 2 {
+		t.Errorf("expected both networks kept, got %+v", stats)
+	}
+	mm, err := NewMaxMind(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := mm.Geolocate(net.ParseIP("81.2.69.1")); err != nil || res.ASN != 64500 {
+		t.Errorf("expected the ASN, got %+v %v", res, err)
+	}
+
+	// a database with nothing we use is an error, not an empty database
+	w, err := NewWriter("Test-Other", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(mustCIDR(t, "192.0.2.0/24"), map[string]interface{}{"is_anycast": true}); err != nil {
+		t.Fatal(err)
+	}
+	var other bytes.Buffer
+	if _, err := w.WriteTo(&other); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Strip(other.Bytes(), StripOptions{ASN: true}); !errors.Is(err, ErrNothingKept) {
+		t.Errorf("expected ErrNothingKept, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package hindsight
+
+import (
+	"net"
+	"strings"
+)
+
+// HostConfig controls how the virtual host of an inbound request is
+// normalised before we store it.
+type HostConfig struct {
+	StripWWW bool              `toml:"strip_www"` // treat www.example.com as example.com
+	Aliases  map[string]string `toml:"aliases"`   // normalised alias -> canonical host
+}
+
+// NormaliseHost cleans up a host as it arrives from `req.Host`, that is
+// it lowercases it, removes any port and any trailing dot (fully qualified
+// form). IPv6 literal hosts keep their brackets.
+func NormaliseHost(host string) string {
+	host = strings.TrimSpace(strings.ToLower(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		if strings.Contains(h, ":") {
+			// IPv6 literal, keep it recognisable as such
+			h = "[" + h + "]"
+		}
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// CanonicalHost normalises the host and then applies the configured aliases.
+// Aliases are matched against the raw (lowercased) form first so that
+// `example.com:8443` can be aliased separately to `example.com`, and then
+// against the normalised form.
+func (hc *HostConfig) CanonicalHost(host string) string {
+	if canon, ok := hc.Aliases[aliasKey(host)]; ok {
+		return canon
+	}
+	n := NormaliseHost(host)
+	if canon, ok := hc.Aliases[n]; ok {
+		return canon
+	}
+	if hc.StripWWW && strings.HasPrefix(n, "www.") {
+		n = n[4:]
+		if canon, ok := hc.Aliases[n]; ok {
+			return canon
+		}
+	}
+	return n
+}
+
+func aliasKey(host string) string {
+	return strings.TrimSuffix(strings.TrimSpace(strings.ToLower(host)), ".")
+}
+
+// cleans up the config as it was read from the file, so that lookups
+// can be done directly on the incoming hosts.
+func (hc *HostConfig) init() {
+	aliases := make(map[string]string, len(hc.Aliases))
+	for from, to := range hc.Aliases {
+		aliases[aliasKey(from)] = NormaliseHost(to)
+	}
+	hc.Aliases = aliases
+}
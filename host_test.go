@@ -0,0 +1,30 @@
+package hindsight
+
+import "testing"
+
+func TestCanonicalHost(t *testing.T) {
+	hc := &HostConfig{
+		StripWWW: true,
+		Aliases: map[string]string{
+			"example.org":      "example.com",
+			"Example.com:8443": "other.example.com",
+		},
+	}
+	hc.init()
+	cases := map[string]string{
+		"example.com":          "example.com",
+		"EXAMPLE.com.":         "example.com",
+		"example.com:443":      "example.com",
+		"www.example.com":      "example.com",
+		"www.example.org:80":   "example.com",
+		"example.com:8443":     "other.example.com",
+		"[2001:db8::1]:8080":   "[2001:db8::1]",
+		"sub.example.com":      "sub.example.com",
+		"www.sub.example.com.": "sub.example.com",
+	}
+	for in, expected := range cases {
+		if actual := hc.CanonicalHost(in); actual != expected {
+			t.Errorf("CanonicalHost(%q): expected %q, got %q", in, expected, actual)
+		}
+	}
+}
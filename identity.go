@@ -0,0 +1,175 @@
+package hindsight
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotation is how often the visitor keys change.
+type Rotation string
+
+const (
+	RotateDaily   Rotation = "daily"   // a new salt each day (UTC)
+	RotateWeekly  Rotation = "weekly"  // a new salt each week, starting Monday (UTC)
+	RotateSession Rotation = "session" // a new key after each period of inactivity, salts are never stored
+)
+
+// the parts of a request that can identify a visitor
+const (
+	IdentityHost           = "host"            // the host, or rather the salt scope
+	IdentityIP             = "ip"              // the remote address
+	IdentityIPNetwork      = "ip_network"      // the remote address, truncated like privacy.truncate_ip
+	IdentityUserAgent      = "user_agent"      // the user-agent string
+	IdentityAcceptLanguage = "accept_language" // the Accept-Language header, if sent
+)
+
+var allIdentityComponents = []string{IdentityHost, IdentityIP, IdentityIPNetwork, IdentityUserAgent, IdentityAcceptLanguage}
+
+// what we used before identities were configurable, and for rows from then.
+var defaultIdentityComponents = []string{IdentityHost, IdentityIP, IdentityUserAgent}
+
+const defaultSessionTimeout = 30 * time.Minute
+
+// how the visitor key is hashed
+const (
+	HashSHA256 = "sha256" // the salt appended to the components
+	HashHMAC   = "hmac"   // HMAC-SHA256 of the components, keyed with the salt
+)
+
+// full length keys, fewer bits make keys less linkable and smaller, at the
+// cost of some visitors sharing a key, see CollisionEstimate.
+const (
+	maxKeyBits = 256
+	minKeyBits = 16
+)
+
+// IdentityConfig is how the anonymous visitor keys are made.
+type IdentityConfig struct {
+	Components     []string `toml:"components"`
+	Rotation       Rotation `toml:"rotation"`
+	SessionTimeout string   `toml:"session_timeout"` // for the session rotation
+	Hash           string   `toml:"hash"`            // "sha256" or "hmac"
+	Bits           int      `toml:"bits"`            // how much of the hash to keep
+
+	sessionTimeout time.Duration
+}
+
+func (ic *IdentityConfig) init() error {
+	if len(ic.Components) == 0 {
+		ic.Components = defaultIdentityComponents
+	}
+	seen := map[string]bool{}
+	for _, comp := range ic.Components {
+		if !containsString(allIdentityComponents, comp) {
+			return fmt.Errorf("unknown identity component %q", comp)
+		}
+		if seen[comp] {
+			return fmt.Errorf("duplicate identity component %q", comp)
+		}
+		seen[comp] = true
+	}
+	if seen[IdentityIP] && seen[IdentityIPNetwork] {
+		return fmt.Errorf("identity components should have only one of %q and %q", IdentityIP, IdentityIPNetwork)
+	}
+	switch ic.Rotation {
+	case "":
+		ic.Rotation = RotateDaily
+	case RotateDaily, RotateWeekly, RotateSession:
+	default:
+		return fmt.Errorf("unknown identity rotation %q", ic.Rotation)
+	}
+	switch ic.Hash {
+	case "":
+		ic.Hash = HashSHA256
+	case HashSHA256, HashHMAC:
+	default:
+		return fmt.Errorf("unknown identity hash %q", ic.Hash)
+	}
+	if ic.Bits == 0 {
+		ic.Bits = maxKeyBits
+	}
+	if ic.Bits < minKeyBits || ic.Bits > maxKeyBits {
+		return fmt.Errorf("identity bits should be between %d and %d", minKeyBits, maxKeyBits)
+	}
+	ic.sessionTimeout = defaultSessionTimeout
+	if ic.SessionTimeout != "" {
+		d, err := time.ParseDuration(ic.SessionTimeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("session_timeout should be a positive duration")
+		}
+		ic.sessionTimeout = d
+	}
+	return nil
+}
+
+// Scheme names how the keys were made, stored with each event so
+// keys from different schemes are never counted together.
+func (ic *IdentityConfig) Scheme() string {
+	return fmt.Sprintf("%s:%s:%s/%d", ic.Rotation, strings.Join(ic.Components, "+"), ic.Hash, ic.Bits)
+}
+
+// the scheme of events stored before it was recorded, and before the
+// hash was configurable, whose keys are the base64 of the full hash.
+var legacyScheme = string(RotateDaily) + ":" + strings.Join(defaultIdentityComponents, "+")
+
+// the number of bits in keys of the scheme.
+func schemeBits(scheme string) int {
+	var bits int
+	if i := strings.LastIndexByte(scheme, '/'); i != -1 {
+		bits, _ = strconv.Atoi(scheme[i+1:])
+	}
+	if bits == 0 {
+		return maxKeyBits
+	}
+	return bits
+}
+
+// Sessions replaces visitor keys with a random one per session, so
+// visits separated by more than the timeout cannot be linked. Only the
+// hashed keys of recent visitors are held, in memory.
+type Sessions struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	active map[string]*session
+	pruned time.Time
+}
+
+type session struct {
+	key  string
+	last time.Time
+}
+
+func NewSessions(timeout time.Duration) *Sessions {
+	return &Sessions{timeout: timeout, active: map[string]*session{}}
+}
+
+// Key returns the session key for the visitor key at time t.
+func (s *Sessions) Key(visitor string, t time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.Sub(s.pruned) > time.Minute {
+		for k, sess := range s.active {
+			if t.Sub(sess.last) > s.timeout {
+				delete(s.active, k)
+			}
+		}
+		s.pruned = t
+	}
+	sess, ok := s.active[visitor]
+	if !ok || t.Sub(sess.last) > s.timeout {
+		b, err := randomSalt()
+		if err != nil {
+			return "", err
+		}
+		sess = &session{key: string(b)}
+		s.active[visitor] = sess
+	}
+	if t.After(sess.last) {
+		sess.last = t
+	}
+	return sess.key, nil
+}
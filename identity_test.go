@@ -0,0 +1,96 @@
+package hindsight
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIdentityComponents(t *testing.T) {
+	salt := []byte("salt")
+	a := &InboundEvent{IP: "192.0.2.1", UserAgent: "Firefox", AcceptLanguage: "en-GB"}
+	b := &InboundEvent{IP: "192.0.2.2", UserAgent: "Firefox", AcceptLanguage: "de"}
+	cases := []struct {
+		components []string
+		same       bool
+	}{
+		{[]string{"host", "ip", "user_agent"}, false},
+		{[]string{"host", "ip_network", "user_agent"}, true},
+		{[]string{"host", "ip_network", "accept_language"}, false},
+		{[]string{"user_agent"}, true},
+	}
+	for _, c := range cases {
+		ic := &IdentityConfig{Components: c.components}
+		if err := ic.init(); err != nil {
+			t.Fatal(err)
+		}
+		ka, kb := ic.UniqueKey(salt, "host:example.com", a), ic.UniqueKey(salt, "host:example.com", b)
+		if (ka == kb) != c.same {
+			t.Errorf("%v: expected same key %v", c.components, c.same)
+		}
+	}
+	bad := &IdentityConfig{Components: []string{"ip", "ip_network"}}
+	if err := bad.init(); err == nil {
+		t.Error("expected an error for both ip and ip_network")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := NewSessions(30 * time.Minute)
+	now := time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)
+	first, _ := s.Key("visitor", now)
+	if again, _ := s.Key("visitor", now.Add(20*time.Minute)); again != first {
+		t.Error("expected the same key within the session")
+	}
+	if other, _ := s.Key("other", now); other == first {
+		t.Error("expected a different key for another visitor")
+	}
+	if later, _ := s.Key("visitor", now.Add(time.Hour)); later == first {
+		t.Error("expected a new key after the session timeout")
+	}
+}
+
+func TestKeyHashing(t *testing.T) {
+	in := &InboundEvent{IP: "192.0.2.1", UserAgent: "Firefox"}
+	full := &IdentityConfig{}
+	hmac := &IdentityConfig{Hash: HashHMAC, Bits: 20}
+	for _, ic := range []*IdentityConfig{full, hmac} {
+		if err := ic.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k := full.UniqueKey([]byte("salt"), "host:example.com", in); len(k) != 32 {
+		t.Errorf("expected a full 32 byte key, got %d bytes", len(k))
+	}
+	k := hmac.UniqueKey([]byte("salt"), "host:example.com", in)
+	if len(k) != 3 || k[2]&0x0f != 0 {
+		t.Errorf("expected a 20 bit key, got %x", k)
+	}
+	if k == hmac.UniqueKey([]byte("pepper"), "host:example.com", in) {
+		t.Error("expected a different key with a different salt")
+	}
+	if bits := schemeBits(hmac.Scheme()); bits != 20 {
+		t.Errorf("expected 20 bits from scheme %q, got %d", hmac.Scheme(), bits)
+	}
+	if bits := schemeBits(legacyScheme); bits != 256 {
+		t.Errorf("expected 256 bits for the legacy scheme, got %d", bits)
+	}
+}
+
+func TestCollisionEstimate(t *testing.T) {
+	cases := []struct {
+		visitors int64
+		bits     int
+		expected float64
+	}{
+		{1, 16, 0},
+		{65536, 32, 0.5},
+		{1000000, 256, 0},
+		{65536, 16, 65536 / math.E},
+	}
+	for _, c := range cases {
+		if actual := CollisionEstimate(c.visitors, c.bits); math.Abs(actual-c.expected) > 0.01*c.expected+1e-9 {
+			t.Errorf("CollisionEstimate(%d, %d): expected %g, got %g", c.visitors, c.bits, c.expected, actual)
+		}
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func ListenForIngestion(ctx context.Context, c *Config, store Storage) error {
+func ListenForIngestion(ctx context.Context, c *Config, pipeline *Pipeline, store Storage) error {
 	l, err := net.Listen("tcp", c.ListenIngestion)
 	if err != nil {
 		return fmt.Errorf("could not start ingestion listener: %w", err)
@@ -57,13 +57,28 @@ func ListenForIngestion(ctx context.Context, c *Config, store Storage) error {
 					log.Warn().Err(err).Str("line", sc.Text()).Msg("bad event from producer")
 					return
 				}
-				ev := mapInboundEvent(c, in)
-				err = store.Store(ev)
+				metricEventsReceived.Add(1)
+				ev, err := pipeline.Process(in)
+				if err == ErrDropEvent {
+					metricEventsDropped.Add(1)
+					log.Trace().Msg("dropped event")
+					continue
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("failed to process event")
+					continue
+				}
+				if ev.Device == string(DeviceBot) && c.Bots.Policy == BotPolicySeparate {
+					err = store.StoreBots(ev)
+				} else {
+					err = store.Store(ev)
+				}
 				if err != nil {
 					// this one is our fault!
 					log.Error().Err(err).Msg("failed to store event")
 					// we should continue though...
 				} else {
+					metricEventsStored.Add(1)
 					// should we log anyway?
 					log.Trace().Interface("evt", ev).Msg("ingested")
 				}
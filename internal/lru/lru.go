@@ -0,0 +1,92 @@
+// Package lru is a fixed size, least recently used, cache safe for
+// concurrent use.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache holds at most its size of entries, evicting the least recently
+// used. A nil *Cache caches nothing.
+type Cache struct {
+	size int
+
+	mu    sync.Mutex
+	ll    *list.List // most recently used at the front
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key   string
+	value interface{}
+}
+
+// New creates a cache of the size, or returns nil if the size is not
+// positive.
+func New(size int) *Cache {
+	if size <= 0 {
+		return nil
+	}
+	return &Cache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+// Get returns the value for the key, if there is one.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Add sets the value for the key, evicting the oldest entry if full.
+func (c *Cache) Add(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*entry).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+	if c.ll.Len() >= c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+}
+
+// Purge removes all the entries.
+func (c *Cache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element, c.size)
+}
+
+// Len is the number of entries in the cache.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
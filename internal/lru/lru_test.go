@@ -0,0 +1,48 @@
+package lru
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	c := New(2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	// a is now the most recently used, so b is evicted
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("expected a=1, got %v %v", v, ok)
+	}
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	c.Add("a", 4)
+	if v, ok := c.Get("a"); !ok || v != 4 {
+		t.Errorf("expected a=4, got %v %v", v, ok)
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("expected c=3, got %v %v", v, ok)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	c := New(0)
+	c.Add("a", 1)
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("expected a nil cache to cache nothing")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	c := New(2)
+	c.Add("a", 1)
+	c.Purge()
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("expected the cache to be empty")
+	}
+	c.Add("b", 2)
+	if v, ok := c.Get("b"); !ok || v != 2 {
+		t.Errorf("expected b=2, got %v %v", v, ok)
+	}
+}
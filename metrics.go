@@ -0,0 +1,52 @@
+package hindsight
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// counters for what the ingestion is doing, these are published with
+// expvar under "hindsight" and served by the UI at /api/metrics
+var metrics = expvar.NewMap("hindsight")
+
+var (
+	metricEventsReceived      = new(expvar.Int)
+	metricEventsStored        = new(expvar.Int)
+	metricEventsDropped       = new(expvar.Int)
+	metricDuplicatesDiscarded = new(expvar.Int)
+
+	// lookup caches in the geoip and useragent processors
+	metricGeoIPCacheHits       = new(expvar.Int)
+	metricGeoIPCacheMisses     = new(expvar.Int)
+	metricUserAgentCacheHits   = new(expvar.Int)
+	metricUserAgentCacheMisses = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("events_received", metricEventsReceived)
+	metrics.Set("events_stored", metricEventsStored)
+	metrics.Set("events_dropped", metricEventsDropped)
+	metrics.Set("duplicates_discarded", metricDuplicatesDiscarded)
+	metrics.Set("geoip_cache_hits", metricGeoIPCacheHits)
+	metrics.Set("geoip_cache_misses", metricGeoIPCacheMisses)
+	metrics.Set("geoip_cache_hit_rate", hitRate(metricGeoIPCacheHits, metricGeoIPCacheMisses))
+	metrics.Set("useragent_cache_hits", metricUserAgentCacheHits)
+	metrics.Set("useragent_cache_misses", metricUserAgentCacheMisses)
+	metrics.Set("useragent_cache_hit_rate", hitRate(metricUserAgentCacheHits, metricUserAgentCacheMisses))
+}
+
+// the fraction of lookups that were hits, 0 if there were none.
+func hitRate(hits, misses *expvar.Int) expvar.Func {
+	return func() interface{} {
+		h, m := hits.Value(), misses.Value()
+		if h+m == 0 {
+			return 0.0
+		}
+		return float64(h) / float64(h+m)
+	}
+}
+
+func serveMetrics(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write([]byte(metrics.String()))
+}
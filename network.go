@@ -0,0 +1,55 @@
+package hindsight
+
+import (
+	"strings"
+)
+
+// the network types, from the autonomous system the visitor is in.
+const (
+	NetworkHosting     = "hosting"     // cloud, hosting and CDN providers, i.e. probably not a person
+	NetworkResidential = "residential" // everything else: ISPs, mobile carriers, businesses...
+	networkUnknown     = "unknown"
+)
+
+// well known hosting provider ASNs, whose names don't give them away.
+var hostingASNs = map[int64]bool{
+	13335:  true, // Cloudflare
+	14061:  true, // DigitalOcean
+	15169:  true, // Google
+	16276:  true, // OVH
+	16509:  true, // Amazon
+	14618:  true, // Amazon
+	20473:  true, // Vultr (The Constant Company)
+	24940:  true, // Hetzner
+	63949:  true, // Linode (Akamai)
+	8075:   true, // Microsoft
+	396982: true, // Google Cloud
+	45102:  true, // Alibaba
+	51167:  true, // Contabo
+	12876:  true, // Scaleway
+}
+
+// lower case substrings of AS organisation names that mean hosting.
+var hostingOrgPatterns = []string{
+	"hosting", "cloud", "data center", "datacenter", "server", "colocation",
+	"vps", "dedicated", "amazon", "digitalocean",
+	"linode", "ovh", "hetzner", "vultr", "akamai", "fastly", "leaseweb",
+}
+
+// NetworkType guesses whether the autonomous system is a hosting provider
+// (so the traffic is probably automated) or something people browse from.
+func NetworkType(asn int64, org string) string {
+	if asn == 0 {
+		return networkUnknown
+	}
+	if hostingASNs[asn] {
+		return NetworkHosting
+	}
+	org = strings.ToLower(org)
+	for _, p := range hostingOrgPatterns {
+		if strings.Contains(org, p) {
+			return NetworkHosting
+		}
+	}
+	return NetworkResidential
+}
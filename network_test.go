@@ -0,0 +1,23 @@
+package hindsight
+
+import "testing"
+
+func TestNetworkType(t *testing.T) {
+	cases := []struct {
+		asn      int64
+		org      string
+		expected string
+	}{
+		{0, "", networkUnknown},
+		{16509, "AMAZON-02", NetworkHosting},
+		{24940, "Hetzner Online GmbH", NetworkHosting},
+		{64500, "Example Cloud Services Ltd", NetworkHosting},
+		{2856, "British Telecommunications PLC", NetworkResidential},
+		{16591, "GOOGLE-FIBER", NetworkResidential},
+	}
+	for _, c := range cases {
+		if actual := NetworkType(c.asn, c.org); actual != c.expected {
+			t.Errorf("NetworkType(%d, %q): expected %s, got %s", c.asn, c.org, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package hindsight
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// SignalPolicy is what we do with hits from browsers sending the Do Not
+// Track (`DNT: 1`) or Global Privacy Control (`Sec-GPC: 1`) headers.
+type SignalPolicy string
+
+const (
+	SignalsIgnore    SignalPolicy = "ignore"    // treat them like any other hit
+	SignalsAnonymous SignalPolicy = "anonymous" // record them without a visitor key
+	SignalsDrop      SignalPolicy = "drop"      // do not record them at all
+)
+
+type PrivacyConfig struct {
+	Signals    SignalPolicy `toml:"signals"`
+	TruncateIP bool         `toml:"truncate_ip"` // see TruncateIP, can be overridden per site
+	SaltGrace  string       `toml:"salt_grace"`  // time either side of midnight to keep both days' salts
+	// record the region (ISO 3166-2 subdivision) as well as the country
+	Regions bool `toml:"regions"`
+	// events older than this many days should be deleted, 0 keeps them forever.
+	// see `hindsight privacy audit`
+	RetentionDays int `toml:"retention_days"`
+
+	saltGrace time.Duration
+}
+
+func (pc *PrivacyConfig) init() error {
+	switch pc.Signals {
+	case "":
+		pc.Signals = SignalsAnonymous
+	case SignalsIgnore, SignalsAnonymous, SignalsDrop:
+	default:
+		return fmt.Errorf("unknown privacy signals policy %q", pc.Signals)
+	}
+	pc.saltGrace = defaultSaltGrace
+	if pc.SaltGrace != "" {
+		grace, err := time.ParseDuration(pc.SaltGrace)
+		if err != nil || grace < 0 || grace > 12*time.Hour {
+			return fmt.Errorf("salt_grace should be a duration between 0 and 12h")
+		}
+		pc.saltGrace = grace
+	}
+	if pc.RetentionDays < 0 {
+		return fmt.Errorf("retention_days should not be negative")
+	}
+	return nil
+}
+
+// honours the DNT and GPC signals, this must come before the visitor,
+// geoip and dedup processors, so nothing is derived from a dropped event.
+func newSignalsProcessor(c *Config, _ *Services, _ func(v interface{}) error) (Processor, error) {
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if c.anonymous(in) {
+			// still a pageview, but not a unique visitor
+			ev.Key = ""
+		} else if (in.DNT || in.GPC) && c.Privacy.Signals == SignalsDrop {
+			return ErrDropEvent
+		}
+		return nil
+	}), nil
+}
+
+// whether the event should be recorded without a visitor key
+func (c *Config) anonymous(in *InboundEvent) bool {
+	return (in.DNT || in.GPC) && c.Privacy.Signals == SignalsAnonymous
+}
+
+// SaltGraceDuration is the parsed salt_grace
+func (pc *PrivacyConfig) SaltGraceDuration() time.Duration {
+	return pc.saltGrace
+}
+
+// TruncateIP removes the host part of an address, keeping the /24 network
+// of an IPv4 address and the /48 of an IPv6 address.
+func TruncateIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
+
+// whether to truncate IP addresses for this canonical host
+func (c *Config) truncateIP(host string) bool {
+	if site := c.Site(host); site != nil && site.TruncateIP != nil {
+		return *site.TruncateIP
+	}
+	return c.Privacy.TruncateIP
+}
+
+// truncates the IP address before anything else uses it. this must come
+// after the host processor (for the per site setting) and before the
+// visitor and geoip processors.
+func newTruncateIPProcessor(c *Config, _ *Services, _ func(v interface{}) error) (Processor, error) {
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if !c.truncateIP(ev.Host) {
+			return nil
+		}
+		if ip := net.ParseIP(in.IP); ip != nil {
+			in.IP = TruncateIP(ip).String()
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,87 @@
+package hindsight
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTruncateIP(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.123":         "192.0.2.0",
+		"::ffff:192.0.2.123":  "192.0.2.0",
+		"2001:db8:abcd:12::1": "2001:db8:abcd::",
+	}
+	for in, expected := range cases {
+		if actual := TruncateIP(net.ParseIP(in)).String(); actual != expected {
+			t.Errorf("TruncateIP(%s): expected %s, got %s", in, expected, actual)
+		}
+	}
+}
+
+func TestSignalsProcessor(t *testing.T) {
+	cases := []struct {
+		policy   string
+		dnt, gpc bool
+		dropped  bool
+		keyed    bool
+	}{
+		{"", false, false, false, true},
+		{"", true, false, false, false},
+		{"", false, true, false, false},
+		{"anonymous", true, true, false, false},
+		{"drop", false, false, false, true},
+		{"drop", true, false, true, false},
+		{"drop", false, true, true, false},
+		{"ignore", true, true, false, true},
+	}
+	for _, c := range cases {
+		_, p, err := testPipeline(t, "processors = [\"signals\", \"visitor\"]\n[privacy]\nsignals = \""+c.policy+"\"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := testInbound("/")
+		in.DNT, in.GPC = c.dnt, c.gpc
+		ev, err := p.Process(in)
+		if dropped := err == ErrDropEvent; dropped != c.dropped {
+			t.Errorf("%q dnt=%v gpc=%v: expected dropped %v, got %v", c.policy, c.dnt, c.gpc, c.dropped, err)
+			continue
+		}
+		if ev != nil && (ev.Key != "") != c.keyed {
+			t.Errorf("%q dnt=%v gpc=%v: expected a key %v, got %q", c.policy, c.dnt, c.gpc, c.keyed, ev.Key)
+		}
+	}
+	c := &Config{Privacy: PrivacyConfig{Signals: "maybe"}}
+	if err := c.init(); err == nil {
+		t.Error("expected an error for an unknown signals policy")
+	}
+}
+
+func TestPrivacyProcessorsRequired(t *testing.T) {
+	yes := true
+	cases := []struct {
+		name       string
+		processors []string
+		privacy    PrivacyConfig
+		sites      map[string]*SiteConfig
+		ok         bool
+	}{
+		{"default pipeline", nil, PrivacyConfig{TruncateIP: true}, nil, true},
+		{"no signals", []string{"visitor"}, PrivacyConfig{}, nil, false},
+		{"signals ignored", []string{"visitor"}, PrivacyConfig{Signals: SignalsIgnore}, nil, true},
+		{"signals", []string{"signals", "visitor"}, PrivacyConfig{Signals: SignalsDrop}, nil, true},
+		{"no truncate_ip", []string{"signals", "visitor"}, PrivacyConfig{TruncateIP: true}, nil, false},
+		{"no truncate_ip for a site", []string{"signals", "visitor"}, PrivacyConfig{}, map[string]*SiteConfig{"example.com": {TruncateIP: &yes}}, false},
+		{"truncate_ip", []string{"truncate_ip", "signals", "visitor"}, PrivacyConfig{TruncateIP: true}, nil, true},
+		{"signals after visitor", []string{"visitor", "signals"}, PrivacyConfig{Signals: SignalsDrop}, nil, false},
+		{"signals after geoip", []string{"geoip", "signals"}, PrivacyConfig{Signals: SignalsDrop}, nil, false},
+		{"truncate_ip after visitor", []string{"signals", "visitor", "truncate_ip"}, PrivacyConfig{TruncateIP: true}, nil, false},
+		{"truncate_ip after dedup", []string{"signals", "dedup", "truncate_ip"}, PrivacyConfig{TruncateIP: true}, nil, false},
+		{"truncate_ip after geoip", []string{"signals", "geoip", "truncate_ip", "visitor"}, PrivacyConfig{TruncateIP: true}, nil, false},
+	}
+	for _, c := range cases {
+		config := &Config{Processors: c.processors, Privacy: c.privacy, Sites: c.sites}
+		if err := config.init(); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.name, c.ok, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package hindsight
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0x6377/hindsight/geoip"
+)
+
+// A Processor enriches or filters events as they are ingested. Processors
+// run in the configured order, each seeing the inbound event and the
+// anonymised event built so far. The inbound event must not be stored
+// anywhere by a processor, as it contains the personal data.
+type Processor interface {
+	Process(in *InboundEvent, ev *Event) error
+}
+
+// ProcessorFunc lets a plain function be a Processor.
+type ProcessorFunc func(in *InboundEvent, ev *Event) error
+
+func (fn ProcessorFunc) Process(in *InboundEvent, ev *Event) error {
+	return fn(in, ev)
+}
+
+// ErrDropEvent should be returned by a processor to stop processing and
+// discard the event.
+var ErrDropEvent = errors.New("event dropped")
+
+// A ProcessorFactory creates a processor from the config. The options
+// for the processor, from the `[processor.<name>]` table, can be read
+// into a struct with decode. If there are no options, decode does nothing.
+type ProcessorFactory func(c *Config, s *Services, decode func(v interface{}) error) (Processor, error)
+
+// Services are the shared (and stateful) parts of hindsight that
+// processors may need.
+type Services struct {
+	Salts   *Salts
+	Locator geoip.Geolocater // the embedded database (or nothing) if nil
+}
+
+var (
+	processorsMu sync.RWMutex
+	processors   = map[string]ProcessorFactory{}
+)
+
+// RegisterProcessor makes a processor available to the pipeline under
+// the given name. It is intended to be called from an init function, and
+// will panic if the name is already taken.
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	if _, exists := processors[name]; exists {
+		panic("hindsight: processor registered twice: " + name)
+	}
+	processors[name] = factory
+}
+
+// ProcessorNames lists the registered processors.
+func ProcessorNames() []string {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	names := make([]string, 0, len(processors))
+	for name := range processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultProcessors is the pipeline used when the config doesn't specify one.
+var DefaultProcessors = []string{"host", "exclude", "path", "truncate_ip", "signals", "visitor", "useragent", "bots", "geoip", "classify", "drop"}
+
+// A Pipeline turns inbound events into anonymised ones by running
+// each of its processors in turn.
+type Pipeline struct {
+	names []string
+	procs []Processor
+}
+
+// NewPipeline creates the processors named in the config, in order.
+func NewPipeline(c *Config, s *Services) (*Pipeline, error) {
+	names := c.Processors
+	if len(names) == 0 {
+		names = DefaultProcessors
+	}
+	p := &Pipeline{names: names}
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	for _, name := range names {
+		factory, ok := processors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q", name)
+		}
+		proc, err := factory(c, s, c.processorOptions(name))
+		if err != nil {
+			return nil, fmt.Errorf("could not create processor %q: %w", name, err)
+		}
+		p.procs = append(p.procs, proc)
+	}
+	return p, nil
+}
+
+// Process maps the inbound event into an Event. If any processor wants
+// the event dropped, the error will be ErrDropEvent.
+func (p *Pipeline) Process(in *InboundEvent) (*Event, error) {
+	// the data we can take as is, the rest is up to the processors.
+	ev := &Event{
+		Time:   in.Time,
+		Host:   in.Host,
+		Method: in.Method,
+		Path:   in.Path,
+		Class:  ClassOther,
+
+		Device: string(DeviceUnknown),
+
+		Duration:     int64(in.Duration / time.Millisecond),
+		BytesWritten: in.BytesWritten,
+		StatusCode:   in.StatusCode,
+	}
+	for i, proc := range p.procs {
+		if err := proc.Process(in, ev); err != nil {
+			if err == ErrDropEvent {
+				return nil, err
+			}
+			return nil, fmt.Errorf("processor %q failed: %w", p.names[i], err)
+		}
+	}
+	return ev, nil
+}
@@ -0,0 +1,328 @@
+package hindsight
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/0x6377/hindsight/geoip"
+	"github.com/0x6377/hindsight/internal/lru"
+	"github.com/rs/zerolog/log"
+)
+
+// the built-in processors
+func init() {
+	RegisterProcessor("host", newHostProcessor)
+	RegisterProcessor("exclude", newExcludeProcessor)
+	RegisterProcessor("path", newPathProcessor)
+	RegisterProcessor("truncate_ip", newTruncateIPProcessor)
+	RegisterProcessor("visitor", newVisitorProcessor)
+	RegisterProcessor("signals", newSignalsProcessor)
+	RegisterProcessor("dedup", newDedupProcessor)
+	RegisterProcessor("useragent", newUserAgentProcessor)
+	RegisterProcessor("bots", newBotsProcessor)
+	RegisterProcessor("geoip", newGeoIPProcessor)
+	RegisterProcessor("classify", newClassifyProcessor)
+	RegisterProcessor("drop", newDropProcessor)
+}
+
+// canonicalises the host. The inbound host is changed too, so the
+// visitor key is the same for all aliases of a host.
+func newHostProcessor(c *Config, _ *Services, _ func(v interface{}) error) (Processor, error) {
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		in.Host = c.Hosts.CanonicalHost(in.Host)
+		ev.Host = in.Host
+		return nil
+	}), nil
+}
+
+type pathOptions struct {
+	StripQuery bool          `toml:"strip_query"` // remove the query string
+	KeepQuery  []string      `toml:"keep_query"`  // except for these parameters
+	Rewrites   []pathRewrite `toml:"rewrites"`    // applied in order, after stripping the query
+}
+
+type pathRewrite struct {
+	Match   string `toml:"match"`   // a regular expression
+	Replace string `toml:"replace"` // with $1 style expansion
+	re      *regexp.Regexp
+}
+
+// cleans up the path, removing query strings and rewriting it.
+func newPathProcessor(c *Config, _ *Services, decode func(v interface{}) error) (Processor, error) {
+	opts := &pathOptions{}
+	if err := decode(opts); err != nil {
+		return nil, err
+	}
+	for i := range opts.Rewrites {
+		re, err := regexp.Compile(opts.Rewrites[i].Match)
+		if err != nil {
+			return nil, fmt.Errorf("bad rewrite %d: %w", i+1, err)
+		}
+		opts.Rewrites[i].re = re
+	}
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if opts.StripQuery {
+			ev.Path = stripQuery(ev.Path, opts.KeepQuery)
+		}
+		for _, rw := range opts.Rewrites {
+			ev.Path = rw.re.ReplaceAllString(ev.Path, rw.Replace)
+		}
+		return nil
+	}), nil
+}
+
+func stripQuery(p string, keep []string) string {
+	i := strings.IndexByte(p, '?')
+	if i == -1 {
+		return p
+	}
+	query, err := url.ParseQuery(p[i+1:])
+	if err != nil || len(keep) == 0 {
+		return p[:i]
+	}
+	kept := url.Values{}
+	for _, k := range keep {
+		if v, ok := query[k]; ok {
+			kept[k] = v
+		}
+	}
+	if len(kept) == 0 {
+		return p[:i]
+	}
+	return p[:i] + "?" + kept.Encode()
+}
+
+// creates the anonymous unique visitor key, as configured by c.Identity.
+func newVisitorProcessor(c *Config, s *Services, _ func(v interface{}) error) (Processor, error) {
+	if s.Salts == nil {
+		return nil, fmt.Errorf("no salts available")
+	}
+	var sessions *Sessions
+	if c.Identity.Rotation == RotateSession {
+		sessions = NewSessions(c.Identity.sessionTimeout)
+	}
+	scheme := c.Identity.Scheme()
+	if c.Identity.Bits < maxKeyBits {
+		log.Info().Int("bits", c.Identity.Bits).
+			Float64("collisions_1k", CollisionEstimate(1000, c.Identity.Bits)).
+			Float64("collisions_100k", CollisionEstimate(100000, c.Identity.Bits)).
+			Msg("truncated visitor keys, expected visitors sharing a key per period")
+	}
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if c.anonymous(in) {
+			return nil
+		}
+		scope := c.SaltScope(ev.Host)
+		salt, err := s.Salts.Salt(scope, in.Time)
+		if err != nil {
+			return err
+		}
+		ev.Key = c.Identity.UniqueKey(salt, scope, in)
+		if sessions != nil {
+			sess, err := sessions.Key(ev.Key, in.Time)
+			if err != nil {
+				return err
+			}
+			ev.Key = c.Identity.truncate([]byte(sess))
+		}
+		ev.Scheme = scheme
+		return nil
+	}), nil
+}
+
+type cacheOptions struct {
+	CacheSize int `toml:"cache_size"` // how many lookups to remember, 0 to disable
+}
+
+const defaultCacheSize = 10000
+
+// works out the device, browser and os from the user-agent.
+func newUserAgentProcessor(c *Config, _ *Services, decode func(v interface{}) error) (Processor, error) {
+	opts := &cacheOptions{CacheSize: defaultCacheSize}
+	if err := decode(opts); err != nil {
+		return nil, err
+	}
+	cache := lru.New(opts.CacheSize)
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		var uainfo *UAInfo
+		if v, ok := cache.Get(in.UserAgent); ok {
+			metricUserAgentCacheHits.Add(1)
+			uainfo = v.(*UAInfo)
+		} else {
+			uainfo = DecodeUserAgent(in.UserAgent)
+			if cache != nil {
+				metricUserAgentCacheMisses.Add(1)
+				cache.Add(in.UserAgent, uainfo)
+			}
+		}
+		ev.Device = string(uainfo.Device)
+		ev.Browser = uainfo.Browser
+		ev.OS = uainfo.OS
+		return nil
+	}), nil
+}
+
+// flags bots the user-agent parser missed, and applies the bot policy.
+func newBotsProcessor(c *Config, _ *Services, _ func(v interface{}) error) (Processor, error) {
+	bots := NewBotDetector(&c.Bots)
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		if !bots.IsBot(in, ev) {
+			return nil
+		}
+		if c.Bots.Policy == BotPolicyDrop {
+			return ErrDropEvent
+		}
+		ev.Device = string(DeviceBot)
+		if ev.Browser.Name == "" {
+			ev.Browser.Name = botName(in.UserAgent)
+		}
+		return nil
+	}), nil
+}
+
+// finds the country, timezone and network from the IP address. Internal
+// addresses get an "internal:<label>" country instead.
+func newGeoIPProcessor(c *Config, s *Services, decode func(v interface{}) error) (Processor, error) {
+	opts := &cacheOptions{CacheSize: defaultCacheSize}
+	if err := decode(opts); err != nil {
+		return nil, err
+	}
+	locator := s.Locator
+	if locator == nil {
+		locator = geoip.Fallback()
+	}
+	locator = geoip.Internal(locator, c.GeoIP.internal...)
+	// the cached results are shared, so must not be changed. they are
+	// keyed by the locator generation too, so a reloaded database isn't
+	// hidden by results from the old one, which are purged.
+	cache := lru.New(opts.CacheSize)
+	var cacheGen uint64
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		gen := geoip.Generation(locator)
+		if old := atomic.LoadUint64(&cacheGen); old != gen && atomic.CompareAndSwapUint64(&cacheGen, old, gen) {
+			cache.Purge()
+		}
+		key := strconv.FormatUint(gen, 10) + "/" + in.IP
+		var loc *geoip.LookupResult
+		if v, ok := cache.Get(key); ok {
+			metricGeoIPCacheHits.Add(1)
+			loc = v.(*geoip.LookupResult)
+		} else {
+			loc = geoip.MustGeolocateWith(locator, net.ParseIP(in.IP))
+			if cache != nil {
+				metricGeoIPCacheMisses.Add(1)
+				cache.Add(key, loc)
+			}
+		}
+		ev.CountryCode = loc.CountryCode
+		ev.TimeZone = loc.Timezone
+		ev.ASN = int64(loc.ASN)
+		ev.ASNOrg = loc.ASNOrg
+		if c.Privacy.Regions {
+			ev.Region = loc.Region
+		}
+		return nil
+	}), nil
+}
+
+// sets the event class from the request and response.
+func newClassifyProcessor(c *Config, _ *Services, _ func(v interface{}) error) (Processor, error) {
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		ev.Class = c.ClassifyRequest(ev.Host, ev.Method, ev.Path, in.ContentType)
+		return nil
+	}), nil
+}
+
+type dropOptions struct {
+	Rules []*DropRule `toml:"rules"`
+}
+
+// A DropRule discards events. All the non-empty criteria must match
+// for the event to be dropped.
+type DropRule struct {
+	Hosts        []string `toml:"hosts"`
+	Methods      []string `toml:"methods"`
+	PathPrefixes []string `toml:"path_prefixes"`
+	Statuses     []int64  `toml:"statuses"`
+	Classes      []Class  `toml:"classes"`
+}
+
+func (r *DropRule) matches(ev *Event) bool {
+	if len(r.Hosts) > 0 && !containsString(r.Hosts, ev.Host) {
+		return false
+	}
+	if len(r.Methods) > 0 && !containsString(r.Methods, ev.Method) {
+		return false
+	}
+	if len(r.PathPrefixes) > 0 {
+		found := false
+		for _, prefix := range r.PathPrefixes {
+			if strings.HasPrefix(ev.Path, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Statuses) > 0 {
+		found := false
+		for _, status := range r.Statuses {
+			if status == ev.StatusCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Classes) > 0 {
+		found := false
+		for _, class := range r.Classes {
+			if class == ev.Class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// discards events matching any of the configured rules.
+func newDropProcessor(c *Config, _ *Services, decode func(v interface{}) error) (Processor, error) {
+	opts := &dropOptions{}
+	if err := decode(opts); err != nil {
+		return nil, err
+	}
+	for i, r := range opts.Rules {
+		for j := range r.Methods {
+			r.Methods[j] = strings.ToUpper(r.Methods[j])
+		}
+		for j := range r.Hosts {
+			r.Hosts[j] = c.Hosts.CanonicalHost(r.Hosts[j])
+		}
+		for _, class := range r.Classes {
+			if _, err := parseClass(string(class)); err != nil {
+				return nil, fmt.Errorf("drop rule %d: %w", i+1, err)
+			}
+		}
+	}
+	return ProcessorFunc(func(in *InboundEvent, ev *Event) error {
+		for _, r := range opts.Rules {
+			if r.matches(ev) {
+				return ErrDropEvent
+			}
+		}
+		return nil
+	}), nil
+}
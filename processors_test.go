@@ -0,0 +1,294 @@
+package hindsight
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x6377/hindsight/geoip"
+	"github.com/BurntSushi/toml"
+)
+
+var benchUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/115.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.5 Safari/605.1.15",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 16_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.5 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Mobile Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36",
+}
+
+// a config from the TOML, and a pipeline for it.
+func testPipeline(t *testing.T, config string) (*Config, *Pipeline, error) {
+	t.Helper()
+	c := &Config{}
+	var err error
+	if c.meta, err = toml.Decode(config, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStorage(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline(c, &Services{
+		Salts:   NewSalts(store, c.Privacy.SaltGraceDuration(), c.Identity.Rotation),
+		Locator: geoip.Noop{},
+	})
+	return c, p, err
+}
+
+func testInbound(path string) *InboundEvent {
+	return &InboundEvent{
+		Time:        time.Now(),
+		IP:          "192.0.2.1",
+		Host:        "WWW.Example.com",
+		Method:      "GET",
+		Path:        path,
+		UserAgent:   firefoxUserAgent,
+		StatusCode:  200,
+		ContentType: "text/html",
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	_, p, err := testPipeline(t, `
+[hosts]
+strip_www = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := p.Process(testInbound("/about"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Host != "example.com" || ev.Path != "/about" || ev.Class != ClassPageview {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev.Key == "" || ev.Browser.Name != "Firefox" || ev.CountryCode != geoip.DefaultCountryCode {
+		t.Errorf("expected a visitor key, browser and country, got %+v", ev)
+	}
+	// the same visitor on another page has the same key
+	if again, err := p.Process(testInbound("/")); err != nil || again.Key != ev.Key {
+		t.Errorf("expected the same key, got %v %v", again, err)
+	}
+
+	// only the processors asked for are run, in order
+	_, p, err = testPipeline(t, `processors = ["path"]
+[privacy]
+signals = "ignore"
+[processor.path]
+rewrites = [{ match = "^/about$", replace = "/about-us" }]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := p.Process(testInbound("/about")); err != nil || ev.Path != "/about-us" || ev.Key != "" || ev.Host != "WWW.Example.com" {
+		t.Errorf("expected only the path to be processed, got %+v %v", ev, err)
+	}
+
+	if _, _, err := testPipeline(t, `processors = ["host", "nothing"]
+[privacy]
+signals = "ignore"
+`); err == nil {
+		t.Error("expected an error for an unknown processor")
+	}
+	if _, _, err := testPipeline(t, `processors = ["path"]
+[privacy]
+signals = "ignore"
+[processor.path]
+rewrites = [{ match = "(", replace = "" }]
+`); err == nil {
+		t.Error("expected an error for a bad rewrite")
+	}
+}
+
+func TestPathProcessor(t *testing.T) {
+	_, p, err := testPipeline(t, `processors = ["path"]
+[privacy]
+signals = "ignore"
+[processor.path]
+strip_query = true
+keep_query = ["page", "q"]
+rewrites = [
+	{ match = "^/posts/[0-9]+-(.*)$", replace = "/posts/$1" },
+	{ match = "/index\\.html$", replace = "/" },
+]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		in, out string
+	}{
+		{"/", "/"},
+		{"/?utm_source=news", "/"},
+		{"/search?q=shoes&utm_source=news&page=2", "/search?page=2&q=shoes"},
+		{"/search?%zz", "/search"},
+		{"/posts/123-hello", "/posts/hello"},
+		{"/docs/index.html?utm_medium=email", "/docs/"},
+	}
+	for _, c := range cases {
+		ev, err := p.Process(testInbound(c.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Path != c.out {
+			t.Errorf("%s: expected %s, got %s", c.in, c.out, ev.Path)
+		}
+	}
+}
+
+func TestDropProcessor(t *testing.T) {
+	_, p, err := testPipeline(t, `processors = ["host", "classify", "drop"]
+[privacy]
+signals = "ignore"
+[hosts]
+strip_www = true
+[[processor.drop.rules]]
+path_prefixes = ["/health", "/metrics"]
+[[processor.drop.rules]]
+hosts = ["www.example.com"]
+methods = ["head"]
+[[processor.drop.rules]]
+statuses = [404]
+classes = ["pageview"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		status  int64
+		dropped bool
+	}{
+		{"page", "GET", "/", 200, false},
+		{"health check", "GET", "/healthz", 200, true},
+		{"metrics", "POST", "/metrics/push", 200, true},
+		{"head request", "HEAD", "/", 200, true},
+		{"missing page", "GET", "/nothing", 404, true},
+		{"missing image", "GET", "/nothing.png", 404, false},
+	}
+	for _, c := range cases {
+		in := testInbound(c.path)
+		in.Method, in.StatusCode = c.method, c.status
+		if c.path == "/nothing.png" {
+			in.ContentType = "image/png"
+		}
+		_, err := p.Process(in)
+		if dropped := err == ErrDropEvent; dropped != c.dropped {
+			t.Errorf("%s: expected dropped %v, got %v", c.name, c.dropped, err)
+		}
+	}
+	if _, _, err := testPipeline(t, `processors = ["drop"]
+[privacy]
+signals = "ignore"
+[[processor.drop.rules]]
+classes = ["nonsense"]
+`); err == nil {
+		t.Error("expected an error for an unknown class")
+	}
+}
+
+func TestGeoIPCacheReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "networks.csv")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("192.0.2.0/24,GB,Europe/London\n")
+	fl, err := geoip.NewFileLocator("cidr", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newGeoIPProcessor(c, &Services{Locator: fl}, func(v interface{}) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	country := func() string {
+		ev := &Event{}
+		if err := p.Process(&InboundEvent{IP: "192.0.2.1"}, ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev.CountryCode
+	}
+	if cc := country(); cc != "GB" {
+		t.Fatalf("expected GB, got %s", cc)
+	}
+	write("192.0.2.0/24,FR,Europe/Paris\n# moved\n")
+	if reloaded, err := fl.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected a reload, got %v %v", reloaded, err)
+	}
+	if cc := country(); cc != "FR" {
+		t.Errorf("expected the cached result to be dropped on reload, got %s", cc)
+	}
+}
+
+// ingestion of events from a few hundred visitors, with and without the
+// geoip and useragent caches.
+func BenchmarkPipeline(b *testing.B) {
+	// a location table about the size of a country level database
+	var table strings.Builder
+	for i := 0; i < 1<<16; i++ {
+		fmt.Fprintf(&table, "%d.%d.0.0/16,GB,Europe/London\n", i>>8, i&0xff)
+	}
+	path := filepath.Join(b.TempDir(), "networks.csv")
+	if err := os.WriteFile(path, []byte(table.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	locator, err := geoip.Open("cidr", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	store, err := NewSQLiteStorage(b.TempDir() + "/bench.db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{0, defaultCacheSize} {
+		b.Run(fmt.Sprintf("cache_size=%d", size), func(b *testing.B) {
+			c := &Config{}
+			opts := fmt.Sprintf("[processor.geoip]\ncache_size = %d\n[processor.useragent]\ncache_size = %d\n", size, size)
+			if c.meta, err = toml.Decode(opts, c); err != nil {
+				b.Fatal(err)
+			}
+			if err := c.init(); err != nil {
+				b.Fatal(err)
+			}
+			p, err := NewPipeline(c, &Services{
+				Salts:   NewSalts(store, c.Privacy.SaltGraceDuration(), c.Identity.Rotation),
+				Locator: locator,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			start := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				in := &InboundEvent{
+					Time:       start.Add(time.Duration(i) * time.Millisecond),
+					IP:         fmt.Sprintf("203.0.%d.%d", i%3, i%100),
+					Host:       "example.com",
+					Method:     "GET",
+					Path:       "/",
+					UserAgent:  benchUserAgents[i%len(benchUserAgents)],
+					StatusCode: 200,
+				}
+				if _, err := p.Process(in); err != nil && err != ErrDropEvent {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package hindsight
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Dimension is a property of an event we can group by.
+type Dimension struct {
+	Name  string
+	Value func(ev *Event) string
+}
+
+var (
+	DimHost    = &Dimension{"host", func(ev *Event) string { return ev.Host }}
+	DimPath    = &Dimension{"path", func(ev *Event) string { return ev.Path }}
+	DimClass   = &Dimension{"class", func(ev *Event) string { return string(ev.Class) }}
+	DimStatus  = &Dimension{"status", func(ev *Event) string { return fmt.Sprint(ev.StatusCode) }}
+	DimDevice  = &Dimension{"device", func(ev *Event) string { return ev.Device }}
+	DimBrowser = &Dimension{"browser", func(ev *Event) string { return ev.Browser.Name }}
+	DimOS      = &Dimension{"os", func(ev *Event) string { return ev.OS.Name }}
+	DimCountry = &Dimension{"country", func(ev *Event) string { return ev.CountryCode }}
+	DimCrawler = &Dimension{"crawler", func(ev *Event) string { return strings.TrimSpace(ev.Browser.Name + " " + ev.Browser.Version) }}
+	DimNetwork = &Dimension{"network", func(ev *Event) string {
+		if ev.ASN == 0 {
+			return networkUnknown
+		}
+		return strings.TrimSpace(fmt.Sprintf("AS%d %s", ev.ASN, ev.ASNOrg))
+	}}
+	DimNetworkType = &Dimension{"network type", func(ev *Event) string { return NetworkType(ev.ASN, ev.ASNOrg) }}
+	DimRegion      = &Dimension{"region", func(ev *Event) string {
+		if ev.Region == "" {
+			return ev.CountryCode
+		}
+		return ev.Region
+	}}
+)
+
+// A Report is a named breakdown of events by one or more dimensions.
+type Report struct {
+	Name       string
+	Title      string
+	Dimensions []*Dimension
+	// The classes of event to include when the query does not specify any.
+	// If empty, only pageviews are counted.
+	Classes []Class
+	// Whether to include bot traffic, when the query does not say. Bots are
+	// excluded if this is not set either.
+	Bots BotFilter
+	// Whether the report can be run, nil if it always can.
+	Enabled func(c *Config) bool
+	// Makes the dimensions from the config, instead of Dimensions, e.g. for
+	// the site owner's time zone.
+	DimensionsFor func(c *Config) []*Dimension
+	// Whether the rows are in time order rather than by visitors. Time
+	// series are never cut short by a limit.
+	Chronological bool
+}
+
+var ErrReportDisabled = errors.New("report is not enabled")
+
+// Available is whether the report is enabled by the config.
+func (r *Report) Available(c *Config) bool {
+	return r.Enabled == nil || r.Enabled(c)
+}
+
+func (r *Report) dimensions(c *Config) []*Dimension {
+	if r.DimensionsFor != nil {
+		return r.DimensionsFor(c)
+	}
+	return r.Dimensions
+}
+
+// sorts the rows of chronological reports into time order, and applies the
+// limit to the others.
+func (r *Report) order(rows []*ReportRow, limit int) []*ReportRow {
+	if r.Chronological {
+		sort.SliceStable(rows, func(i, j int) bool {
+			a, b := rows[i].Values, rows[j].Values
+			for k := range a {
+				if a[k] != b[k] {
+					return timeValueLess(a[k], b[k])
+				}
+			}
+			return false
+		})
+		return rows
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+var reports = []*Report{
+	{Name: "hosts", Title: "Sites", Dimensions: []*Dimension{DimHost}},
+	{Name: "days", Title: "Visits by Day", Chronological: true, DimensionsFor: func(c *Config) []*Dimension {
+		return []*Dimension{DimDay(c.Reports.Location())}
+	}},
+	{Name: "hours", Title: "Visits by Hour", Chronological: true, DimensionsFor: func(c *Config) []*Dimension {
+		return []*Dimension{DimHour(c.Reports.Location())}
+	}},
+	{Name: "local-hours", Title: "Visits by Visitor's Hour", Dimensions: []*Dimension{DimLocalHour}, Chronological: true},
+	{Name: "local-weekdays", Title: "Visits by Visitor's Day of the Week", Dimensions: []*Dimension{DimLocalWeekday}, Chronological: true},
+	{Name: "pages", Title: "Top Pages", Dimensions: []*Dimension{DimHost, DimPath}},
+	{Name: "countries", Title: "Countries", Dimensions: []*Dimension{DimCountry}},
+	{Name: "regions", Title: "Regions", Dimensions: []*Dimension{DimRegion}, Enabled: func(c *Config) bool { return c.Privacy.Regions }},
+	{Name: "networks", Title: "Networks", Dimensions: []*Dimension{DimNetworkType, DimNetwork}},
+	{Name: "devices", Title: "Devices", Dimensions: []*Dimension{DimDevice}},
+	{Name: "browsers", Title: "Browsers", Dimensions: []*Dimension{DimBrowser}},
+	{Name: "os", Title: "Operating Systems", Dimensions: []*Dimension{DimOS}},
+	{Name: "status", Title: "Response Status", Dimensions: []*Dimension{DimStatus}},
+	{Name: "classes", Title: "Request Classes", Dimensions: []*Dimension{DimClass}, Classes: AllClasses},
+	{Name: "crawlers", Title: "Crawler Activity", Dimensions: []*Dimension{DimCrawler, DimHost}, Classes: AllClasses, Bots: BotsOnly},
+}
+
+// Reports lists all the available reports.
+func Reports() []*Report {
+	return reports
+}
+
+// LookupReport finds a report by name, or returns nil.
+func LookupReport(name string) *Report {
+	for _, r := range reports {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// ReportConfig controls what the reports are allowed to show.
+type ReportConfig struct {
+	// Rows with fewer unique visitors than this are grouped into an "Other"
+	// row (or suppressed), so rare combinations cannot single anyone out.
+	MinVisitors int    `toml:"min_visitors"`
+	Suppress    bool   `toml:"suppress"`  // drop the rows rather than group them
+	TimeZone    string `toml:"time_zone"` // the site owner's, for dates and the reports by day and hour, default UTC
+
+	loc *time.Location
+}
+
+func (rc *ReportConfig) init() error {
+	if rc.MinVisitors < 0 {
+		return fmt.Errorf("reports.min_visitors should not be negative")
+	}
+	rc.loc = time.UTC
+	if rc.TimeZone != "" {
+		loc, err := time.LoadLocation(rc.TimeZone)
+		if err != nil {
+			return fmt.Errorf("bad reports.time_zone: %w", err)
+		}
+		rc.loc = loc
+	}
+	return nil
+}
+
+// Location is the site owner's time zone.
+func (rc *ReportConfig) Location() *time.Location {
+	if rc.loc == nil {
+		return time.UTC
+	}
+	return rc.loc
+}
+
+// the values for the row of groups below the threshold
+const otherValue = "Other"
+
+// ReportQuery is the range of events to run a report over.
+type ReportQuery struct {
+	From, Until time.Time
+	Filter      Filter
+	Limit       int // maximum rows, 0 for all
+}
+
+type ReportRow struct {
+	Values   []string
+	Hits     int64
+	Visitors int64
+}
+
+type ReportResult struct {
+	Name, Title string
+	From, Until time.Time
+	Classes     []Class
+	Columns     []string
+	Rows        []*ReportRow
+	Total       ReportRow
+	Suppressed  int      // number of rows below the visitor threshold
+	Schemes     []string // the visitor key schemes, visitors are counted separately for each
+	Collisions  float64  // estimated visitors missing from the total, as they share a key
+}
+
+// the query filter with the report defaults filled in.
+func (r *Report) filter(q *ReportQuery) Filter {
+	filter := q.Filter
+	if len(filter.Classes) == 0 {
+		filter.Classes = r.Classes
+		if len(filter.Classes) == 0 {
+			filter.Classes = []Class{ClassPageview}
+		}
+	}
+	if filter.Bots == BotsDefault {
+		filter.Bots = r.Bots
+	}
+	if filter.Bots == BotsDefault {
+		filter.Bots = BotsExclude
+	}
+	return filter
+}
+
+// RunReport fetches the events for the query and aggregates them, applying
+// the minimum visitor threshold from the config.
+func RunReport(c *Config, store Storage, r *Report, q *ReportQuery) (*ReportResult, error) {
+	if !r.Available(c) {
+		return nil, ErrReportDisabled
+	}
+	filter := r.filter(q)
+	events, err := store.Fetch(q.From, q.Until, &filter)
+	if err != nil {
+		return nil, err
+	}
+	dims := r.dimensions(c)
+	res := &ReportResult{
+		Name:    r.Name,
+		Title:   r.Title,
+		From:    q.From,
+		Until:   q.Until,
+		Classes: filter.Classes,
+		Columns: make([]string, len(dims)),
+		Rows:    aggregate(events, dims),
+	}
+	for i, d := range dims {
+		res.Columns[i] = d.Name
+	}
+	res.Total = ReportRow{Hits: int64(len(events)), Visitors: countVisitors(events)}
+	res.Schemes = visitorSchemes(events)
+	res.Collisions = estimateCollisions(events)
+	// bots are not people, so there is no one to protect.
+	var other *ReportRow
+	if filter.Bots != BotsOnly {
+		rc := c.Reports
+		// an "Other" day or hour makes no sense in a timeline, so they are
+		// left out instead.
+		if r.Chronological {
+			rc.Suppress = true
+		}
+		res.Rows, other, res.Total, res.Suppressed = applyThreshold(&rc, events, dims, res.Rows)
+	}
+	res.Rows = r.order(res.Rows, q.Limit)
+	if other != nil {
+		res.Rows = append(res.Rows, other)
+	}
+	return res, nil
+}
+
+// groups the events by the dimensions, counting hits and unique visitors,
+// with the rows sorted by visitors, then hits, descending.
+func aggregate(events []*Event, dims []*Dimension) []*ReportRow {
+	type group struct {
+		row      *ReportRow
+		visitors map[string]struct{}
+	}
+	groups := map[string]*group{}
+	values := make([]string, len(dims))
+	for _, ev := range events {
+		for i, d := range dims {
+			values[i] = d.Value(ev)
+		}
+		k := strings.Join(values, "\x00")
+		g, ok := groups[k]
+		if !ok {
+			g = &group{
+				row:      &ReportRow{Values: append([]string(nil), values...)},
+				visitors: map[string]struct{}{},
+			}
+			groups[k] = g
+		}
+		g.row.Hits++
+		// anonymous hits have no key, and are not unique visitors
+		if v := ev.visitor(); v != "" {
+			g.visitors[v] = struct{}{}
+		}
+	}
+	rows := make([]*ReportRow, 0, len(groups))
+	for _, g := range groups {
+		g.row.Visitors = int64(len(g.visitors))
+		rows = append(rows, g.row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Visitors != b.Visitors {
+			return a.Visitors > b.Visitors
+		}
+		if a.Hits != b.Hits {
+			return a.Hits > b.Hits
+		}
+		return strings.Join(a.Values, "\x00") < strings.Join(b.Values, "\x00")
+	})
+	return rows
+}
+
+// groups the rows below the threshold into one "Other" row, unless they should
+// be suppressed. The other row is suppressed too if it is still too small. It
+// is returned apart from the kept rows, so it can go after them whatever the
+// limit. The total only counts events in the kept and other rows, so it
+// doesn't give away what was suppressed.
+func applyThreshold(rc *ReportConfig, events []*Event, dims []*Dimension, rows []*ReportRow) ([]*ReportRow, *ReportRow, ReportRow, int) {
+	if rc.MinVisitors <= 1 {
+		return rows, nil, ReportRow{Hits: int64(len(events)), Visitors: countVisitors(events)}, 0
+	}
+	kept := make([]*ReportRow, 0, len(rows))
+	below := map[string]bool{}
+	for _, row := range rows {
+		if row.Visitors >= int64(rc.MinVisitors) {
+			kept = append(kept, row)
+		} else {
+			below[strings.Join(row.Values, "\x00")] = true
+		}
+	}
+	// we need the events to count the unique visitors in the total and the
+	// other row.
+	other := &ReportRow{Values: make([]string, len(dims))}
+	for i := range other.Values {
+		other.Values[i] = otherValue
+	}
+	var total ReportRow
+	visitors, otherVisitors := map[string]struct{}{}, map[string]struct{}{}
+	values := make([]string, len(dims))
+	for _, ev := range events {
+		for i, d := range dims {
+			values[i] = d.Value(ev)
+		}
+		row, seen := &total, visitors
+		if below[strings.Join(values, "\x00")] {
+			row, seen = other, otherVisitors
+		}
+		row.Hits++
+		if v := ev.visitor(); v != "" {
+			seen[v] = struct{}{}
+		}
+	}
+	other.Visitors = int64(len(otherVisitors))
+	if rc.Suppress || other.Visitors < int64(rc.MinVisitors) {
+		other = nil
+	} else {
+		total.Hits += other.Hits
+		for v := range otherVisitors {
+			visitors[v] = struct{}{}
+		}
+	}
+	total.Visitors = int64(len(visitors))
+	return kept, other, total, len(below)
+}
+
+func countVisitors(events []*Event) int64 {
+	visitors := map[string]struct{}{}
+	for _, ev := range events {
+		if v := ev.visitor(); v != "" {
+			visitors[v] = struct{}{}
+		}
+	}
+	return int64(len(visitors))
+}
+
+// the expected number of visitors counted as one, as they share a key.
+func estimateCollisions(events []*Event) float64 {
+	perScheme := map[string]map[string]struct{}{}
+	for _, ev := range events {
+		if ev.Key == "" {
+			continue
+		}
+		if perScheme[ev.Scheme] == nil {
+			perScheme[ev.Scheme] = map[string]struct{}{}
+		}
+		perScheme[ev.Scheme][ev.Key] = struct{}{}
+	}
+	var total float64
+	for scheme, visitors := range perScheme {
+		total += CollisionEstimate(int64(len(visitors)), schemeBits(scheme))
+	}
+	return total
+}
+
+// the visitor key schemes used by the events, sorted.
+func visitorSchemes(events []*Event) []string {
+	seen := map[string]bool{}
+	schemes := []string{}
+	for _, ev := range events {
+		if ev.Key != "" && !seen[ev.Scheme] {
+			seen[ev.Scheme] = true
+			schemes = append(schemes, ev.Scheme)
+		}
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// ParseBotFilter reads "exclude", "include" or "only", or "" for the
+// report's default.
+func ParseBotFilter(s string) (BotFilter, error) {
+	switch s {
+	case "":
+		return BotsDefault, nil
+	case "exclude":
+		return BotsExclude, nil
+	case "include":
+		return BotsInclude, nil
+	case "only":
+		return BotsOnly, nil
+	}
+	return BotsDefault, fmt.Errorf("bots should be one of exclude, include or only, not %q", s)
+}
+
+// ParseTime accepts either a full RFC3339 timestamp or a plain date,
+// which is taken as midnight UTC.
+func ParseTime(s string) (time.Time, error) {
+	return ParseTimeIn(s, time.UTC)
+}
+
+// ParseTimeIn is ParseTime with plain dates taken as midnight in the
+// location, e.g. the site owner's time zone.
+func ParseTimeIn(s string, loc *time.Location) (time.Time, error) {
+	t, _, err := parseTimeIn(s, loc)
+	return t, err
+}
+
+// ParseEndTimeIn is ParseTimeIn for the end of a range, where a plain date
+// is the last second of that day, so the day is included.
+func ParseEndTimeIn(s string, loc *time.Location) (time.Time, error) {
+	t, date, err := parseTimeIn(s, loc)
+	if err != nil || !date {
+		return t, err
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+// also returns whether it was a plain date.
+func parseTimeIn(s string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return t, true, fmt.Errorf("time %q is neither a date (YYYY-MM-DD) or RFC3339", s)
+	}
+	return t, true, nil
+}
@@ -0,0 +1,144 @@
+package hindsight
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestApplyThreshold(t *testing.T) {
+	var events []*Event
+	add := func(country string, visitors int) {
+		for i := 0; i < visitors; i++ {
+			events = append(events, &Event{Key: fmt.Sprintf("%s-%d", country, i), CountryCode: country})
+		}
+	}
+	add("GB", 5)
+	add("FR", 3)
+	add("IS", 1)
+	add("LI", 1)
+	add("MC", 1)
+	dims := []*Dimension{DimCountry}
+	rows := aggregate(events, dims)
+
+	grouped, other, total, n := applyThreshold(&ReportConfig{MinVisitors: 3}, events, dims, rows)
+	if n != 3 {
+		t.Errorf("expected 3 rows below the threshold, got %d", n)
+	}
+	if len(grouped) != 2 {
+		t.Fatalf("expected GB and FR rows, got %d rows", len(grouped))
+	}
+	if other == nil || other.Values[0] != otherValue || other.Visitors != 3 || other.Hits != 3 {
+		t.Errorf("expected Other row with 3 visitors and hits, got %+v", other)
+	}
+	if total.Visitors != 11 || total.Hits != 11 {
+		t.Errorf("expected a total of everything, got %+v", total)
+	}
+
+	suppressed, other, total, _ := applyThreshold(&ReportConfig{MinVisitors: 3, Suppress: true}, events, dims, rows)
+	if len(suppressed) != 2 || other != nil {
+		t.Errorf("expected only GB and FR rows, got %d rows and %+v", len(suppressed), other)
+	}
+	if total.Visitors != 8 || total.Hits != 8 {
+		t.Errorf("expected the total to leave out the suppressed rows, got %+v", total)
+	}
+
+	// the other row is too small itself, so it goes too
+	events = nil
+	add("GB", 5)
+	add("IS", 1)
+	tiny, other, total, _ := applyThreshold(&ReportConfig{MinVisitors: 3}, events, dims, aggregate(events, dims))
+	if len(tiny) != 1 || tiny[0].Values[0] != "GB" || other != nil {
+		t.Errorf("expected only the GB row, got %d rows", len(tiny))
+	}
+	if total.Visitors != 5 || total.Hits != 5 {
+		t.Errorf("expected the total to leave out the small other row, got %+v", total)
+	}
+}
+
+func TestReportOtherAfterLimit(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	add := func(country string, visitors int) {
+		for i := 0; i < visitors; i++ {
+			ev := &Event{Time: now, Key: fmt.Sprintf("%s-%d", country, i), CountryCode: country, Class: ClassPageview, Host: "example.com", Path: "/"}
+			if err := store.Store(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add("GB", 6)
+	add("FR", 5)
+	add("DE", 4)
+	add("IS", 1)
+	add("LI", 1)
+	add("MC", 1)
+	c := &Config{}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	c.Reports.MinVisitors = 3
+	res, err := RunReport(c, store, LookupReport("countries"), &ReportQuery{From: now.Add(-time.Hour), Until: now.Add(time.Hour), Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range res.Rows {
+		got = append(got, row.Values[0])
+	}
+	if fmt.Sprint(got) != fmt.Sprint([]string{"GB", "FR", otherValue}) {
+		t.Errorf("expected the top two and the other row, got %v", got)
+	}
+	if res.Total.Visitors != 18 {
+		t.Errorf("expected a total of 18 visitors, got %d", res.Total.Visitors)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	cases := []struct {
+		s           string
+		from, until time.Time
+	}{
+		{"2022-01-02", time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 2, 23, 59, 59, 0, time.UTC)},
+		{"2022-01-02T10:00:00Z", time.Date(2022, 1, 2, 10, 0, 0, 0, time.UTC), time.Date(2022, 1, 2, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		from, err := ParseTimeIn(c.s, time.UTC)
+		if err != nil || !from.Equal(c.from) {
+			t.Errorf("%s: expected from %s, got %s %v", c.s, c.from, from, err)
+		}
+		until, err := ParseEndTimeIn(c.s, time.UTC)
+		if err != nil || !until.Equal(c.until) {
+			t.Errorf("%s: expected until %s, got %s %v", c.s, c.until, until, err)
+		}
+	}
+	if _, err := ParseEndTimeIn("yesterday", time.UTC); err == nil {
+		t.Error("expected an error for a bad time")
+	}
+}
+
+func TestRegionReport(t *testing.T) {
+	r := LookupReport("regions")
+	c := &Config{}
+	if r.Available(c) {
+		t.Error("expected the regions report to be disabled by default")
+	}
+	if _, err := RunReport(c, nil, r, &ReportQuery{}); err != ErrReportDisabled {
+		t.Errorf("expected ErrReportDisabled, got %v", err)
+	}
+	c.Privacy.Regions = true
+	if !r.Available(c) {
+		t.Error("expected the regions report to be enabled")
+	}
+	rows := aggregate([]*Event{
+		{Key: "a", CountryCode: "GB", Region: "GB-SCT"},
+		{Key: "b", CountryCode: "GB", Region: "GB-SCT"},
+		{Key: "c", CountryCode: "FR"},
+	}, r.Dimensions)
+	if len(rows) != 2 || rows[0].Values[0] != "GB-SCT" || rows[1].Values[0] != "FR" {
+		t.Errorf("unexpected rows %v, %v", rows[0], rows[1])
+	}
+}
@@ -0,0 +1,184 @@
+package hindsight
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SaltStore persists the salt for the current day, so the visitor keys stay
+// the same across restarts. Salts must be deleted as soon as they are no
+// longer needed, so that nobody can recompute old visitor keys.
+type SaltStore interface {
+	// LoadOrCreateSalt returns the stored salt for the scope and day,
+	// storing the given one if there was none.
+	LoadOrCreateSalt(scope string, day int64, salt []byte) ([]byte, error)
+	// DeleteSaltsBefore securely removes the salts for all earlier days.
+	DeleteSaltsBefore(day int64) error
+}
+
+// default time either side of midnight (UTC) that we keep both days' salts,
+// for late events from yesterday or clocks running a little fast.
+const defaultSaltGrace = 15 * time.Minute
+
+const saltSize = 32
+
+// salts are per scope (a site, or group of sites) and period, the
+// period being stored as its first day.
+type saltKey struct {
+	scope string
+	day   int64
+}
+
+// Salts hands out the random daily salts, a different one for each scope,
+// so visitor keys from different scopes can never be correlated. Only the
+// salts for the current day (and the neighbouring day within the grace
+// period around midnight) are stored. Events from any other day, e.g. from
+// importing old logs, get a salt that only exists in memory until the next
+// rotation, so their keys are consistent within an import but can never be
+// recomputed.
+type Salts struct {
+	store    SaltStore
+	grace    time.Duration
+	rotation Rotation
+	now      func() time.Time
+
+	mu        sync.Mutex
+	current   map[saltKey][]byte // from the store
+	ephemeral map[saltKey][]byte // never stored
+	rotated   int64              // the first day we still keep salts for
+}
+
+// NewSalts creates the salts for the rotation. Weekly salts are kept for the
+// week rather than the day, and session salts are never stored at all.
+func NewSalts(store SaltStore, grace time.Duration, rotation Rotation) *Salts {
+	return &Salts{
+		store:     store,
+		grace:     grace,
+		rotation:  rotation,
+		now:       time.Now,
+		current:   map[saltKey][]byte{},
+		ephemeral: map[saltKey][]byte{},
+	}
+}
+
+// day since unix epoch
+func saltDay(t time.Time) int64 {
+	return t.Unix() / 86400
+}
+
+// the first day of the period containing t
+func (s *Salts) period(t time.Time) int64 {
+	day := saltDay(t)
+	if s.rotation == RotateWeekly {
+		// the epoch was a Thursday
+		return day - (day+3)%7
+	}
+	return day
+}
+
+// the periods we keep stored salts for, at time now.
+func (s *Salts) window(now time.Time) (first, last int64) {
+	first, last = s.period(now), s.period(now)
+	if p := s.period(now.Add(-s.grace)); p < first {
+		first = p
+	}
+	if p := s.period(now.Add(s.grace)); p > last {
+		last = p
+	}
+	return first, last
+}
+
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate any randomness: %w", err)
+	}
+	return salt, nil
+}
+
+// Salt returns the salt for the scope in the period of t.
+func (s *Salts) Salt(scope string, t time.Time) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if err := s.rotate(now); err != nil {
+		return nil, err
+	}
+	day := s.period(t)
+	k := saltKey{scope: scope, day: day}
+	first, last := s.window(now)
+	if day < first || day > last || s.rotation == RotateSession {
+		if salt, ok := s.ephemeral[k]; ok {
+			return salt, nil
+		}
+		salt, err := randomSalt()
+		if err != nil {
+			return nil, err
+		}
+		s.ephemeral[k] = salt
+		return salt, nil
+	}
+	if salt, ok := s.current[k]; ok {
+		return salt, nil
+	}
+	salt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	salt, err = s.store.LoadOrCreateSalt(scope, day, salt)
+	if err != nil {
+		return nil, fmt.Errorf("could not load salt: %w", err)
+	}
+	s.current[k] = salt
+	return salt, nil
+}
+
+// forget everything from before the window. must be called with the lock held.
+func (s *Salts) rotate(now time.Time) error {
+	first, _ := s.window(now)
+	if first <= s.rotated {
+		return nil
+	}
+	if err := s.store.DeleteSaltsBefore(first); err != nil {
+		return fmt.Errorf("could not delete old salts: %w", err)
+	}
+	for k := range s.current {
+		if k.day < first {
+			delete(s.current, k)
+		}
+	}
+	// the ephemeral ones are only for a period.
+	s.ephemeral = map[saltKey][]byte{}
+	s.rotated = first
+	log.Debug().Int64("day", first).Msg("rotated salts")
+	return nil
+}
+
+// Rotate deletes salts which are no longer needed.
+func (s *Salts) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotate(s.now())
+}
+
+// RotateSalts makes sure old salts are deleted on time even when there
+// are no events to trigger it, until the context is done.
+func RotateSalts(ctx context.Context, s *Salts) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Rotate(); err != nil {
+				log.Error().Err(err).Msg("failed to rotate salts")
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package hindsight
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+func countSalts(t *testing.T, store *SQLiteStorage) int {
+	var n int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM hindsight_salts;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSaltLifecycle(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/salts.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 1, 2, 0, 5, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	salts := NewSalts(store, 15*time.Minute, RotateDaily)
+	salts.now = clock
+
+	today, _ := salts.Salt("host:example.com", now)
+	yesterday, _ := salts.Salt("host:example.com", now.Add(-10*time.Minute))
+	if bytes.Equal(today, yesterday) {
+		t.Error("expected different salts for different days")
+	}
+	if n := countSalts(t, store); n != 2 {
+		t.Errorf("expected both days' salts stored in the grace period, got %d", n)
+	}
+	old, _ := salts.Salt("host:example.com", now.AddDate(0, 0, -7))
+	oldAgain, _ := salts.Salt("host:example.com", now.AddDate(0, 0, -7))
+	if !bytes.Equal(old, oldAgain) {
+		t.Error("expected the same salt for the same old day until rotation")
+	}
+	if n := countSalts(t, store); n != 2 {
+		t.Errorf("expected old days' salts never to be stored, got %d salts", n)
+	}
+
+	if other, _ := salts.Salt("host:example.org", now); bytes.Equal(today, other) {
+		t.Error("expected different salts for different scopes")
+	}
+	if n := countSalts(t, store); n != 3 {
+		t.Errorf("expected a salt stored for each scope, got %d", n)
+	}
+
+	// after the grace period, yesterday's salt should be gone
+	now = now.Add(time.Hour)
+	if err := salts.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSalts(t, store); n != 2 {
+		t.Errorf("expected only today's salts after the grace period, got %d", n)
+	}
+	if again, _ := salts.Salt("host:example.com", now.AddDate(0, 0, -7)); bytes.Equal(old, again) {
+		t.Error("expected old days' salts to be forgotten on rotation")
+	}
+
+	// a restart should give the same salt for today
+	restarted := NewSalts(store, 15*time.Minute, RotateDaily)
+	restarted.now = clock
+	if again, _ := restarted.Salt("host:example.com", now); !bytes.Equal(today, again) {
+		t.Error("expected the same salt for today after a restart")
+	}
+}
+
+func TestSaltRotations(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/salts.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a Sunday
+	now := time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)
+	weekly := NewSalts(store, 15*time.Minute, RotateWeekly)
+	weekly.now = func() time.Time { return now }
+	sunday, _ := weekly.Salt("host:example.com", now)
+	wednesday, _ := weekly.Salt("host:example.com", now.AddDate(0, 0, -4))
+	if !bytes.Equal(sunday, wednesday) {
+		t.Error("expected the same salt all week")
+	}
+	if monday, _ := weekly.Salt("host:example.com", now.AddDate(0, 0, 1)); bytes.Equal(sunday, monday) {
+		t.Error("expected a new salt on Monday")
+	}
+	if n := countSalts(t, store); n != 1 {
+		t.Errorf("expected only this week's salt stored, got %d", n)
+	}
+
+	store, err = NewSQLiteStorage(t.TempDir() + "/sessions.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := NewSalts(store, 15*time.Minute, RotateSession)
+	session.now = func() time.Time { return now }
+	if _, err := session.Salt("host:example.org", now); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSalts(t, store); n != 0 {
+		t.Errorf("expected session salts never to be stored, got %d salts", n)
+	}
+}
+
+func TestSaltMigrationSecureDelete(t *testing.T) {
+	path := t.TempDir() + "/old.db"
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a database from before salts were per scope
+	stmts := append([]string{
+		`PRAGMA journal_mode=WAL;`,
+		`CREATE TABLE hindsight_schema (version INTEGER NOT NULL, time NUMERIC NOT NULL);`,
+		`INSERT INTO hindsight_schema (version, time) VALUES (4, 0);`,
+	}, schemaMigrations[:4]...)
+	stmts = append(stmts, `INSERT INTO hindsight_salts (day, salt) VALUES (1, 'an-old-salt-nobody-should-find');`)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	store, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+	if n := countSalts(t, store); n != 0 {
+		t.Errorf("expected the old salts to be gone, got %d", n)
+	}
+	for _, name := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("an-old-salt-nobody-should-find")) {
+			t.Errorf("expected the old salt to be overwritten in %s", name)
+		}
+	}
+}
+
+func TestSecureDeleteReset(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/salts.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+	// so the next query gets the connection the delete used
+	store.db.SetMaxOpenConns(1)
+	if err := store.DeleteSaltsBefore(1); err != nil {
+		t.Fatal(err)
+	}
+	var on int
+	if err := store.db.QueryRow(`PRAGMA secure_delete;`).Scan(&on); err != nil {
+		t.Fatal(err)
+	}
+	if on != 0 {
+		t.Error("expected secure_delete to be off again on the pooled connection")
+	}
+}
@@ -4,10 +4,22 @@ import "time"
 
 type Storage interface {
 	Store(evts ...*Event) error
+	StoreBots(evts ...*Event) error // when bots are stored separately
 	Fetch(from, until time.Time, filter *Filter) ([]*Event, error)
 }
 
 type Filter struct {
 	HostList []string
+	Classes  []Class   // all classes if empty
+	Bots     BotFilter // bots are excluded by default
 	// what else?
 }
+
+type BotFilter int
+
+const (
+	BotsDefault BotFilter = iota // not given, bots are excluded unless a report says otherwise
+	BotsExclude                  // only events not from bots
+	BotsInclude                  // all events
+	BotsOnly                     // only events from bots
+)
@@ -1,11 +1,13 @@
 package hindsight
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/rs/zerolog/log"
 	_ "modernc.org/sqlite"
 )
 
@@ -14,7 +16,7 @@ type SQLiteStorage struct {
 }
 
 // schema version, will run the migrations up until that point
-const currentSchemaVersion = 1
+const currentSchemaVersion = 10
 
 // current schema, table is different, as we will migrate data on
 // startup
@@ -38,8 +40,116 @@ var schemaMigrations = []string{
 		location_country_code TEXT NOT NULL,
 		location_time_zone TEXT NOT NULL
 	);`, // lets go the naive route and just list the fields
+	// 1 - add the event class, events from before we classified them
+	// were all counted, so treat them as pageviews until they are
+	// reclassified (see reclassifyMigration).
+	`ALTER TABLE hindsight_events ADD COLUMN req_class TEXT NOT NULL DEFAULT 'pageview';`,
+	// 2 - a table for bot traffic, when stored separately
+	`CREATE TABLE hindsight_bot_events (
+		id INTEGER PRIMARY KEY,
+		time INTEGER NOT NULL,
+		unique_visitor TEXT NOT NULL,
+		req_host TEXT NOT NULL,
+		req_path TEXT NOT NULL,
+		req_method TEXT NOT NULL,
+		res_status INTEGER NOT NULL,
+		res_duration_ms INTEGER NOT NULL,
+		res_bytes_written INTEGER NOT NULL,
+		browser_kind TEXT NOT NULL,
+		browser_name TEXT NOT NULL,
+		browser_version TEXT NOT NULL,
+		os_name TEXT NOT NULL,
+		os_version TEXT NOT NULL,
+		location_country_code TEXT NOT NULL,
+		location_time_zone TEXT NOT NULL,
+		req_class TEXT NOT NULL DEFAULT 'pageview'
+	);`,
+	// 3 - the random daily salts, only ever one or two rows
+	`CREATE TABLE hindsight_salts (
+		day INTEGER PRIMARY KEY,
+		salt BLOB NOT NULL
+	);`,
+	// 4 - salts are per site (or group of sites), the old ones are not
+	// needed as the new keys will not match them anyway. run with secure
+	// delete (see secureMigrations), so the old salts are overwritten.
+	`DELETE FROM hindsight_salts;
+	DROP TABLE hindsight_salts;
+	CREATE TABLE hindsight_salts (
+		scope TEXT NOT NULL,
+		day INTEGER NOT NULL,
+		salt BLOB NOT NULL,
+		PRIMARY KEY (scope, day)
+	);`,
+	// 5 - privacy budget spent on exports, by period of data
+	`CREATE TABLE hindsight_privacy_budget (
+		period TEXT PRIMARY KEY,
+		spent REAL NOT NULL
+	);`,
+	// 6 - the visitor key scheme, existing rows used the original one
+	`ALTER TABLE hindsight_events ADD COLUMN visitor_scheme TEXT NOT NULL DEFAULT '` + legacyScheme + `';
+	ALTER TABLE hindsight_bot_events ADD COLUMN visitor_scheme TEXT NOT NULL DEFAULT '` + legacyScheme + `';`,
+	// 7 - visitor keys are raw (maybe truncated) hashes, so a BLOB, and NULL
+	// for anonymous events. the old base64 text keys are kept as they were,
+	// as bytes, and the scheme tells them apart.
+	rebuildEventsTable(eventsTable) + rebuildEventsTable(botEventsTable),
+	// 8 - the autonomous system of the remote address, 0 if unknown
+	`ALTER TABLE hindsight_events ADD COLUMN location_asn INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE hindsight_events ADD COLUMN location_asn_org TEXT NOT NULL DEFAULT '';
+	ALTER TABLE hindsight_bot_events ADD COLUMN location_asn INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE hindsight_bot_events ADD COLUMN location_asn_org TEXT NOT NULL DEFAULT '';`,
+	// 9 - the ISO 3166-2 region, if enabled
+	`ALTER TABLE hindsight_events ADD COLUMN location_region TEXT NOT NULL DEFAULT '';
+	ALTER TABLE hindsight_bot_events ADD COLUMN location_region TEXT NOT NULL DEFAULT '';`,
 }
 
+// SQLite cannot change a column type, so we copy the table.
+func rebuildEventsTable(table string) string {
+	return `CREATE TABLE ` + table + `_new (
+		id INTEGER PRIMARY KEY,
+		time INTEGER NOT NULL,
+		unique_visitor BLOB,
+		visitor_scheme TEXT NOT NULL,
+		req_host TEXT NOT NULL,
+		req_path TEXT NOT NULL,
+		req_method TEXT NOT NULL,
+		req_class TEXT NOT NULL,
+		res_status INTEGER NOT NULL,
+		res_duration_ms INTEGER NOT NULL,
+		res_bytes_written INTEGER NOT NULL,
+		browser_kind TEXT NOT NULL,
+		browser_name TEXT NOT NULL,
+		browser_version TEXT NOT NULL,
+		os_name TEXT NOT NULL,
+		os_version TEXT NOT NULL,
+		location_country_code TEXT NOT NULL,
+		location_time_zone TEXT NOT NULL
+	);
+	INSERT INTO ` + table + `_new SELECT
+		id, time, NULLIF(CAST(unique_visitor AS BLOB), x''), visitor_scheme,
+		req_host, req_path, req_method, req_class,
+		res_status, res_duration_ms, res_bytes_written,
+		browser_kind, browser_name, browser_version,
+		os_name, os_version,
+		location_country_code, location_time_zone
+	FROM ` + table + `;
+	DROP TABLE ` + table + `;
+	ALTER TABLE ` + table + `_new RENAME TO ` + table + `;
+	`
+}
+
+// the migration adding the event class, after which the old events should
+// be reclassified.
+const reclassifyMigration = 1
+
+// the migrations deleting salts, which are run with secure delete.
+var secureMigrations = map[int]bool{4: true}
+
+// the tables events are stored in
+const (
+	eventsTable    = "hindsight_events"
+	botEventsTable = "hindsight_bot_events"
+)
+
 func NewSQLiteStorage(dsn string) (*SQLiteStorage, error) {
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
@@ -58,10 +168,23 @@ func NewSQLiteStorage(dsn string) (*SQLiteStorage, error) {
 	}
 	// while the schema version is less than target run a migration
 	for ; schemaVersion < currentSchemaVersion; schemaVersion++ {
-		_, err = db.Exec(schemaMigrations[schemaVersion])
+		if secureMigrations[schemaVersion] {
+			err = withSecureDelete(context.Background(), db, func(conn *sql.Conn) (bool, error) {
+				_, err := conn.ExecContext(context.Background(), schemaMigrations[schemaVersion])
+				return true, err
+			})
+		} else {
+			_, err = db.Exec(schemaMigrations[schemaVersion])
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to migrate from schema version %d: %w", schemaVersion, err)
 		}
+		if schemaVersion == reclassifyMigration {
+			var n int64
+			if db.QueryRow(`SELECT COUNT(*) FROM hindsight_events;`).Scan(&n); n > 0 {
+				log.Warn().Int64("events", n).Msg("existing events are now all pageviews, run `hindsight reclassify` to fix the assets, feeds and api calls among them")
+			}
+		}
 		db.Exec(`INSERT INTO hindsight_schema (version, time) VALUES (?, ?);`, schemaVersion+1, time.Now().Unix())
 		if err != nil {
 			return nil, fmt.Errorf("failed to update schema version table for version %d: %w", schemaVersion+1, err)
@@ -72,29 +195,41 @@ func NewSQLiteStorage(dsn string) (*SQLiteStorage, error) {
 }
 
 func (s *SQLiteStorage) Store(evts ...*Event) error {
+	return s.insert(eventsTable, evts)
+}
+
+// StoreBots stores bot events separately from the rest.
+func (s *SQLiteStorage) StoreBots(evts ...*Event) error {
+	return s.insert(botEventsTable, evts)
+}
+
+func (s *SQLiteStorage) insert(table string, evts []*Event) error {
 	// bulk insert is tricky, but SQLite is quick with single inserts.
 	for i, ev := range evts {
-		_, err := s.db.Exec(`INSERT INTO hindsight_events (
-			time, unique_visitor,
-			req_host, req_path, req_method,
+		_, err := s.db.Exec(`INSERT INTO `+table+` (
+			time, unique_visitor, visitor_scheme,
+			req_host, req_path, req_method, req_class,
 			res_status, res_duration_ms, res_bytes_written,
 			browser_kind, browser_name, browser_version,
 			os_name, os_version,
-			location_country_code, location_time_zone)
+			location_country_code, location_time_zone,
+			location_asn, location_asn_org, location_region)
 		VALUES (
-			?,?,
 			?,?,?,
+			?,?,?,?,
 			?,?,?,
 			?,?,?,
 			?,?,
-			?,?
+			?,?,
+			?,?,?
 		);`,
-			ev.Time.Unix(), ev.Key,
-			ev.Host, ev.Path, ev.Method,
+			ev.Time.Unix(), keyBlob(ev.Key), ev.Scheme,
+			ev.Host, ev.Path, ev.Method, ev.Class,
 			ev.StatusCode, ev.Duration, ev.BytesWritten,
 			ev.Device, ev.Browser.Name, ev.Browser.Version,
 			ev.OS.Name, ev.OS.Version,
 			ev.CountryCode, ev.TimeZone,
+			ev.ASN, ev.ASNOrg, ev.Region,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to store event %d/%d: %w", i+1, len(evts), err)
@@ -103,44 +238,70 @@ func (s *SQLiteStorage) Store(evts ...*Event) error {
 	return nil
 }
 
+// anonymous events have no key, which is stored as NULL.
+func keyBlob(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
 func (s *SQLiteStorage) Fetch(from, until time.Time, filter *Filter) ([]*Event, error) {
-	query := `
-		SELECT time, unique_visitor,
-			req_host, req_path, req_method,
-			res_status, res_duration_ms, res_bytes_written,
-			browser_kind, browser_name, browser_version,
-			os_name, os_version,
-			location_country_code, location_time_zone
-		FROM hindsight_events WHERE time BETWEEN ? AND ?
-	`
+	where := "time BETWEEN ? AND ?"
 	args := []interface{}{from.Unix(), until.Unix()}
+	bots := BotsExclude
 	if filter != nil {
 		if filter.HostList != nil && len(filter.HostList) > 0 {
-			query += "AND (" + strings.Repeat("req_host = ? OR ", len(filter.HostList)-1) + "req_host = ?)"
+			where += " AND (" + strings.Repeat("req_host = ? OR ", len(filter.HostList)-1) + "req_host = ?)"
 			for _, host := range filter.HostList {
 				args = append(args, host)
 			}
 		}
+		if len(filter.Classes) > 0 {
+			where += " AND req_class IN (" + strings.Repeat("?, ", len(filter.Classes)-1) + "?)"
+			for _, class := range filter.Classes {
+				args = append(args, class)
+			}
+		}
+		bots = filter.Bots
+	}
+	// the bot table only has bots in, so we don't need to check the kind
+	var query string
+	switch bots {
+	case BotsDefault, BotsExclude:
+		query = selectEvents(eventsTable, where+" AND browser_kind != ?")
+		args = append(args, DeviceBot)
+	case BotsOnly:
+		query = selectEvents(eventsTable, where+" AND browser_kind = ?") + " UNION ALL " + selectEvents(botEventsTable, where)
+		args = append(append(args, DeviceBot), args...)
+	default:
+		query = selectEvents(eventsTable, where) + " UNION ALL " + selectEvents(botEventsTable, where)
+		args = append(args, args...)
 	}
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying for events: %w", err)
 	}
+	defer rows.Close()
 	events := []*Event{}
 	for rows.Next() {
 		next := &Event{}
 		var unix int64
+		var key []byte
 		err := rows.Scan(
-			&unix,
-			&(next.Host), &(next.Path), &(next.Method),
+			&unix, &key, &(next.Scheme),
+			&(next.Host), &(next.Path), &(next.Method), &(next.Class),
 			&(next.StatusCode), &(next.Duration), &(next.BytesWritten),
 			&(next.Device), &(next.Browser.Name), &(next.Browser.Version),
 			&(next.OS.Name), &(next.OS.Version),
 			&(next.CountryCode), &(next.TimeZone),
+			&(next.ASN), &(next.ASNOrg), &(next.Region),
 		)
 		if err != nil {
 			return events, fmt.Errorf("error scanning row: %w", err)
 		}
+		next.Time = time.Unix(unix, 0).UTC()
+		next.Key = string(key)
 		events = append(events, next)
 	}
 	if err = rows.Err(); err != nil {
@@ -149,3 +310,298 @@ func (s *SQLiteStorage) Fetch(from, until time.Time, filter *Filter) ([]*Event,
 
 	return events, nil
 }
+
+func selectEvents(table, where string) string {
+	return `
+		SELECT time, unique_visitor, visitor_scheme,
+			req_host, req_path, req_method, req_class,
+			res_status, res_duration_ms, res_bytes_written,
+			browser_kind, browser_name, browser_version,
+			os_name, os_version,
+			location_country_code, location_time_zone,
+			location_asn, location_asn_org, location_region
+		FROM ` + table + ` WHERE ` + where
+}
+
+// Hosts lists the distinct virtual hosts we have stored events for,
+// along with the number of events for each.
+func (s *SQLiteStorage) Hosts() (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT req_host, COUNT(*) FROM (
+		SELECT req_host FROM hindsight_events UNION ALL SELECT req_host FROM hindsight_bot_events
+	) GROUP BY req_host;`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for hosts: %w", err)
+	}
+	defer rows.Close()
+	hosts := map[string]int64{}
+	for rows.Next() {
+		var host string
+		var count int64
+		if err := rows.Scan(&host, &count); err != nil {
+			return hosts, fmt.Errorf("error scanning row: %w", err)
+		}
+		hosts[host] = count
+	}
+	if err = rows.Err(); err != nil {
+		return hosts, fmt.Errorf("error while scanning rows: %w", err)
+	}
+	return hosts, nil
+}
+
+// RenameHost rewrites the host of all stored events for host `from` to `to`.
+// It returns the number of events changed.
+func (s *SQLiteStorage) RenameHost(from, to string) (int64, error) {
+	var total int64
+	for _, table := range []string{eventsTable, botEventsTable} {
+		res, err := s.db.Exec(`UPDATE `+table+` SET req_host = ? WHERE req_host = ?;`, to, from)
+		if err != nil {
+			return total, fmt.Errorf("failed to rename host %q to %q: %w", from, to, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// StoredRequest is a distinct host, method and path of stored events.
+type StoredRequest struct {
+	Host, Method, Path string
+}
+
+// Pageviews lists the distinct requests stored as pageviews, along with the
+// number of events for each.
+func (s *SQLiteStorage) Pageviews() (map[StoredRequest]int64, error) {
+	rows, err := s.db.Query(`SELECT req_host, req_method, req_path, COUNT(*) FROM (
+		SELECT req_host, req_method, req_path FROM hindsight_events WHERE req_class = ?
+		UNION ALL
+		SELECT req_host, req_method, req_path FROM hindsight_bot_events WHERE req_class = ?
+	) GROUP BY req_host, req_method, req_path;`, ClassPageview, ClassPageview)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for pageviews: %w", err)
+	}
+	defer rows.Close()
+	reqs := map[StoredRequest]int64{}
+	for rows.Next() {
+		var req StoredRequest
+		var count int64
+		if err := rows.Scan(&req.Host, &req.Method, &req.Path, &count); err != nil {
+			return reqs, fmt.Errorf("error scanning row: %w", err)
+		}
+		reqs[req] = count
+	}
+	if err = rows.Err(); err != nil {
+		return reqs, fmt.Errorf("error while scanning rows: %w", err)
+	}
+	return reqs, nil
+}
+
+// ReclassifyPageviews changes the class of the events stored as pageviews
+// for the request. It returns the number of events changed.
+func (s *SQLiteStorage) ReclassifyPageviews(req StoredRequest, class Class) (int64, error) {
+	var total int64
+	for _, table := range []string{eventsTable, botEventsTable} {
+		res, err := s.db.Exec(`UPDATE `+table+` SET req_class = ? WHERE req_class = ? AND req_host = ? AND req_method = ? AND req_path = ?;`,
+			class, ClassPageview, req.Host, req.Method, req.Path)
+		if err != nil {
+			return total, fmt.Errorf("failed to reclassify %s %s%s: %w", req.Method, req.Host, req.Path, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// Paths lists the distinct paths we have stored events for, along with the
+// number of events for each.
+func (s *SQLiteStorage) Paths() (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT req_path, COUNT(*) FROM (
+		SELECT req_path FROM hindsight_events UNION ALL SELECT req_path FROM hindsight_bot_events
+	) GROUP BY req_path;`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for paths: %w", err)
+	}
+	defer rows.Close()
+	paths := map[string]int64{}
+	for rows.Next() {
+		var path string
+		var count int64
+		if err := rows.Scan(&path, &count); err != nil {
+			return paths, fmt.Errorf("error scanning row: %w", err)
+		}
+		paths[path] = count
+	}
+	if err = rows.Err(); err != nil {
+		return paths, fmt.Errorf("error while scanning rows: %w", err)
+	}
+	return paths, nil
+}
+
+// ReplacePath rewrites the path of all stored events for path `from` to `to`.
+// It returns the number of events changed. As this is used to redact paths,
+// the old ones are overwritten and the write-ahead-log truncated.
+func (s *SQLiteStorage) ReplacePath(from, to string) (int64, error) {
+	ctx := context.Background()
+	var total int64
+	err := withSecureDelete(ctx, s.db, func(conn *sql.Conn) (bool, error) {
+		for _, table := range []string{eventsTable, botEventsTable} {
+			res, err := conn.ExecContext(ctx, `UPDATE `+table+` SET req_path = ? WHERE req_path = ?;`, to, from)
+			if err != nil {
+				return total > 0, fmt.Errorf("failed to replace path %q: %w", from, err)
+			}
+			n, _ := res.RowsAffected()
+			total += n
+		}
+		return total > 0, nil
+	})
+	return total, err
+}
+
+// CountEventsBefore counts the stored events from before t.
+func (s *SQLiteStorage) CountEventsBefore(t time.Time) (int64, error) {
+	var total int64
+	for _, table := range []string{eventsTable, botEventsTable} {
+		var n int64
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE time < ?;`, t.Unix()).Scan(&n); err != nil {
+			return total, fmt.Errorf("failed to count events: %w", err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// DeleteEventsBefore removes the stored events from before t, overwriting
+// them like ReplacePath. It returns the number of events deleted.
+func (s *SQLiteStorage) DeleteEventsBefore(t time.Time) (int64, error) {
+	ctx := context.Background()
+	var total int64
+	err := withSecureDelete(ctx, s.db, func(conn *sql.Conn) (bool, error) {
+		for _, table := range []string{eventsTable, botEventsTable} {
+			res, err := conn.ExecContext(ctx, `DELETE FROM `+table+` WHERE time < ?;`, t.Unix())
+			if err != nil {
+				return total > 0, fmt.Errorf("failed to delete events: %w", err)
+			}
+			n, _ := res.RowsAffected()
+			total += n
+		}
+		return total > 0, nil
+	})
+	return total, err
+}
+
+// LoadOrCreateSalt implements SaltStore
+func (s *SQLiteStorage) LoadOrCreateSalt(scope string, day int64, salt []byte) ([]byte, error) {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO hindsight_salts (scope, day, salt) VALUES (?, ?, ?);`, scope, day, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store salt: %w", err)
+	}
+	var stored []byte
+	err = s.db.QueryRow(`SELECT salt FROM hindsight_salts WHERE scope = ? AND day = ?;`, scope, day).Scan(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+	return stored, nil
+}
+
+// DeleteSaltsBefore implements SaltStore. The salts are overwritten on
+// deletion, and the write-ahead-log is truncated so no copy remains there.
+func (s *SQLiteStorage) DeleteSaltsBefore(day int64) error {
+	ctx := context.Background()
+	return withSecureDelete(ctx, s.db, func(conn *sql.Conn) (bool, error) {
+		res, err := conn.ExecContext(ctx, `DELETE FROM hindsight_salts WHERE day < ?;`, day)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete salts: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil
+	})
+}
+
+// runs fn on a connection with secure_delete on, so deleted data is
+// overwritten, then if fn deleted anything truncates the write-ahead-log so
+// no copy remains there either.
+func withSecureDelete(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) (bool, error)) error {
+	// secure_delete is per connection, so we need to hold on to one.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA secure_delete = ON;`); err != nil {
+		return fmt.Errorf("failed to enable secure delete: %w", err)
+	}
+	// the connection goes back to the pool, which shouldn't pay for this.
+	defer conn.ExecContext(ctx, `PRAGMA secure_delete = OFF;`)
+	deleted, err := fn(conn)
+	if err != nil || !deleted {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		return fmt.Errorf("failed to checkpoint after deleting: %w", err)
+	}
+	return nil
+}
+
+// SaltDays lists the days we have salts stored for, in any scope.
+func (s *SQLiteStorage) SaltDays() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT day FROM hindsight_salts ORDER BY day;`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for salts: %w", err)
+	}
+	defer rows.Close()
+	days := []int64{}
+	for rows.Next() {
+		var day int64
+		if err := rows.Scan(&day); err != nil {
+			return days, fmt.Errorf("error scanning row: %w", err)
+		}
+		days = append(days, day)
+	}
+	if err = rows.Err(); err != nil {
+		return days, fmt.Errorf("error while scanning rows: %w", err)
+	}
+	return days, nil
+}
+
+// SpendBudget implements BudgetStore
+func (s *SQLiteStorage) SpendBudget(periods []string, epsilon, budget float64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, p := range periods {
+		var spent float64
+		err := tx.QueryRow(`SELECT spent FROM hindsight_privacy_budget WHERE period = ?;`, p).Scan(&spent)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("could not read privacy budget: %w", err)
+		}
+		if spent+epsilon > budget {
+			return fmt.Errorf("%w for %s: spent %g of %g", ErrBudgetExhausted, p, spent, budget)
+		}
+		_, err = tx.Exec(`INSERT INTO hindsight_privacy_budget (period, spent) VALUES (?, ?)
+			ON CONFLICT (period) DO UPDATE SET spent = spent + excluded.spent;`, p, epsilon)
+		if err != nil {
+			return fmt.Errorf("could not update privacy budget: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SpentBudget implements BudgetStore
+func (s *SQLiteStorage) SpentBudget() (map[string]float64, error) {
+	rows, err := s.db.Query(`SELECT period, spent FROM hindsight_privacy_budget;`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying privacy budget: %w", err)
+	}
+	defer rows.Close()
+	spent := map[string]float64{}
+	for rows.Next() {
+		var p string
+		var e float64
+		if err := rows.Scan(&p, &e); err != nil {
+			return spent, fmt.Errorf("error scanning row: %w", err)
+		}
+		spent[p] = e
+	}
+	return spent, rows.Err()
+}
@@ -0,0 +1,89 @@
+package hindsight
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0x6377/hindsight/geoip"
+)
+
+// the value of the local time dimensions when we don't know the visitor's
+// time zone.
+const timeUnknown = "unknown"
+
+// DimDay is the date of the event in the location, e.g. the site owner's
+// time zone.
+func DimDay(loc *time.Location) *Dimension {
+	return &Dimension{"day", func(ev *Event) string { return ev.Time.In(loc).Format("2006-01-02") }}
+}
+
+// DimHour is the hour of the day of the event in the location.
+func DimHour(loc *time.Location) *Dimension {
+	return &Dimension{"hour", func(ev *Event) string { return fmt.Sprintf("%02d", ev.Time.In(loc).Hour()) }}
+}
+
+var (
+	// the hour of the day for the visitor
+	DimLocalHour = &Dimension{"local hour", func(ev *Event) string {
+		t, ok := localTime(ev)
+		if !ok {
+			return timeUnknown
+		}
+		return fmt.Sprintf("%02d", t.Hour())
+	}}
+	// the day of the week for the visitor
+	DimLocalWeekday = &Dimension{"local weekday", func(ev *Event) string {
+		t, ok := localTime(ev)
+		if !ok {
+			return timeUnknown
+		}
+		return t.Weekday().String()
+	}}
+)
+
+// the week starts on Monday, as in ISO 8601.
+var weekdayOrder = map[string]int{
+	"Monday": 0, "Tuesday": 1, "Wednesday": 2, "Thursday": 3,
+	"Friday": 4, "Saturday": 5, "Sunday": 6,
+}
+
+// whether a is before b, for the time dimensions. Anything that isn't a
+// time, like "unknown" or "Other", goes last.
+func timeValueLess(a, b string) bool {
+	wa, aok := weekdayOrder[a]
+	wb, bok := weekdayOrder[b]
+	if aok && bok {
+		return wa < wb
+	}
+	ta, tb := a != timeUnknown && a != otherValue, b != timeUnknown && b != otherValue
+	if ta != tb {
+		return ta
+	}
+	return a < b
+}
+
+// time zones by name, nil if they can't be loaded.
+var zones sync.Map
+
+// localTime is the time of the event in the visitor's time zone, if we know
+// it. The default timezone from geolocation means we don't.
+func localTime(ev *Event) (time.Time, bool) {
+	name := ev.TimeZone
+	if name == "" || name == geoip.DefaultTimezone {
+		return time.Time{}, false
+	}
+	v, ok := zones.Load(name)
+	if !ok {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			loc = nil
+		}
+		v, _ = zones.LoadOrStore(name, loc)
+	}
+	loc := v.(*time.Location)
+	if loc == nil {
+		return time.Time{}, false
+	}
+	return ev.Time.In(loc), true
+}
@@ -0,0 +1,116 @@
+package hindsight
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLocalTimeDimensions(t *testing.T) {
+	// a Monday evening in London, which is Tuesday morning in Tokyo
+	at := time.Date(2022, 6, 6, 20, 30, 0, 0, time.UTC)
+	cases := []struct {
+		tz, hour, weekday string
+	}{
+		{"Europe/London", "21", "Monday"},
+		{"Asia/Tokyo", "05", "Tuesday"},
+		{"America/Los_Angeles", "13", "Monday"},
+		{"Etc/UTC", timeUnknown, timeUnknown},
+		{"", timeUnknown, timeUnknown},
+		{"Not/AZone", timeUnknown, timeUnknown},
+	}
+	for _, c := range cases {
+		ev := &Event{Time: at, TimeZone: c.tz}
+		if h := DimLocalHour.Value(ev); h != c.hour {
+			t.Errorf("%q: expected hour %s, got %s", c.tz, c.hour, h)
+		}
+		if d := DimLocalWeekday.Value(ev); d != c.weekday {
+			t.Errorf("%q: expected %s, got %s", c.tz, c.weekday, d)
+		}
+	}
+}
+
+func TestOwnerTimeZone(t *testing.T) {
+	c := &Config{Reports: ReportConfig{TimeZone: "Pacific/Auckland"}}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{Time: time.Date(2022, 6, 6, 20, 30, 0, 0, time.UTC)}
+	dims := LookupReport("days").dimensions(c)
+	if d := dims[0].Value(ev); d != "2022-06-07" {
+		t.Errorf("expected the day in Auckland, got %s", d)
+	}
+	if h := DimHour(c.Reports.Location()).Value(ev); h != "08" {
+		t.Errorf("expected the hour in Auckland, got %s", h)
+	}
+	from, err := ParseTimeIn("2022-06-07", c.Reports.Location())
+	if err != nil || !from.Equal(time.Date(2022, 6, 6, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected midnight in Auckland, got %s %v", from, err)
+	}
+	if err := (&Config{Reports: ReportConfig{TimeZone: "Not/AZone"}}).init(); err == nil {
+		t.Error("expected an error for a bad time zone")
+	}
+}
+
+func TestChronologicalOrder(t *testing.T) {
+	r := LookupReport("local-weekdays")
+	rows := r.order([]*ReportRow{
+		{Values: []string{"Sunday"}, Visitors: 9},
+		{Values: []string{timeUnknown}, Visitors: 8},
+		{Values: []string{"Monday"}, Visitors: 1},
+		{Values: []string{otherValue}, Visitors: 3},
+		{Values: []string{"Wednesday"}, Visitors: 5},
+	}, 2)
+	var got []string
+	for _, row := range rows {
+		got = append(got, row.Values[0])
+	}
+	expected := []string{"Monday", "Wednesday", "Sunday", otherValue, timeUnknown}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestChronologicalThreshold(t *testing.T) {
+	store, err := NewSQLiteStorage(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2022, 6, 6, 12, 0, 0, 0, time.UTC)
+	add := func(at time.Time, visitors int) {
+		for i := 0; i < visitors; i++ {
+			ev := &Event{Time: at, Key: fmt.Sprintf("%d-%d", at.Unix(), i), Class: ClassPageview, Host: "example.com", Path: "/"}
+			if err := store.Store(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add(day, 3)
+	add(day.AddDate(0, 0, 1), 1)
+	add(day.AddDate(0, 0, 2), 1)
+	add(day.AddDate(0, 0, 3), 3)
+	c := &Config{}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	c.Reports.MinVisitors = 2
+	res, err := RunReport(c, store, LookupReport("days"), &ReportQuery{From: day.AddDate(0, 0, -1), Until: day.AddDate(0, 0, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range res.Rows {
+		got = append(got, row.Values[0])
+	}
+	if fmt.Sprint(got) != "[2022-06-06 2022-06-09]" {
+		t.Errorf("expected the small days to be left out, got %v", got)
+	}
+	if res.Suppressed != 2 || res.Total.Visitors != 6 {
+		t.Errorf("expected 2 suppressed days and 6 visitors, got %d and %d", res.Suppressed, res.Total.Visitors)
+	}
+}
@@ -0,0 +1,236 @@
+package hindsight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// the dashboard shows this many rows of each report
+const dashboardRows = 10
+
+// ListenForUI serves the dashboard and the JSON reporting API. There is no
+// authentication, so it should only listen on loopback, or be behind a proxy
+// that authenticates.
+func ListenForUI(ctx context.Context, c *Config, store Storage) error {
+	l, err := net.Listen("tcp", c.ListenUI)
+	if err != nil {
+		return fmt.Errorf("could not start ui listener: %w", err)
+	}
+	if !isLoopback(l.Addr()) {
+		log.Warn().Str("addr", l.Addr().String()).Msg("the ui has no authentication, but is not listening on loopback, make sure a proxy authenticates access to it")
+	}
+	srv := &http.Server{Handler: NewUIHandler(c, store)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err = srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// NewUIHandler creates the http.Handler for the dashboard and API.
+func NewUIHandler(c *Config, store Storage) http.Handler {
+	ui := &uiHandler{c: c, store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ui.dashboard)
+	mux.HandleFunc("/api/reports", ui.listReports)
+	mux.HandleFunc("/api/reports/", ui.report)
+	mux.HandleFunc("/api/metrics", serveMetrics)
+	mux.HandleFunc("/exclude", ui.exclude)
+	return mux
+}
+
+type uiHandler struct {
+	c     *Config
+	store Storage
+}
+
+// parses the common query parameters: from, until, host, class, bots and limit.
+// the default range is the last 7 days, and plain dates are in loc.
+func parseReportQuery(v url.Values, loc *time.Location) (*ReportQuery, error) {
+	q := &ReportQuery{
+		Until: time.Now().UTC(),
+	}
+	q.From = q.Until.AddDate(0, 0, -7)
+	var err error
+	if s := v.Get("from"); s != "" {
+		if q.From, err = ParseTimeIn(s, loc); err != nil {
+			return nil, err
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = ParseEndTimeIn(s, loc); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range v["host"] {
+		if h != "" {
+			q.Filter.HostList = append(q.Filter.HostList, h)
+		}
+	}
+	for _, s := range v["class"] {
+		if s == "" {
+			continue
+		}
+		class, err := parseClass(s)
+		if err != nil {
+			return nil, err
+		}
+		q.Filter.Classes = append(q.Filter.Classes, class)
+	}
+	if q.Filter.Bots, err = ParseBotFilter(v.Get("bots")); err != nil {
+		return nil, err
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return nil, fmt.Errorf("limit should be a non-negative integer")
+		}
+	}
+	return q, nil
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("failed to write api response")
+	}
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, map[string]string{"Error": err.Error()})
+}
+
+func (ui *uiHandler) listReports(rw http.ResponseWriter, req *http.Request) {
+	type reportInfo struct{ Name, Title string }
+	list := []reportInfo{}
+	for _, r := range Reports() {
+		if r.Available(ui.c) {
+			list = append(list, reportInfo{r.Name, r.Title})
+		}
+	}
+	writeJSON(rw, http.StatusOK, list)
+}
+
+func (ui *uiHandler) report(rw http.ResponseWriter, req *http.Request) {
+	r := LookupReport(strings.TrimPrefix(req.URL.Path, "/api/reports/"))
+	if r == nil || !r.Available(ui.c) {
+		writeJSONError(rw, http.StatusNotFound, fmt.Errorf("no such report"))
+		return
+	}
+	q, err := parseReportQuery(req.URL.Query(), ui.c.Reports.Location())
+	if err != nil {
+		writeJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+	res, err := RunReport(ui.c, ui.store, r, q)
+	if err != nil {
+		log.Error().Err(err).Str("report", r.Name).Msg("failed to run report")
+		writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("failed to run report"))
+		return
+	}
+	writeJSON(rw, http.StatusOK, res)
+}
+
+func (ui *uiHandler) dashboard(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(rw, req)
+		return
+	}
+	q, err := parseReportQuery(req.URL.Query(), ui.c.Reports.Location())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Limit = dashboardRows
+	data := &dashboardData{
+		From:    q.From.Format("2006-01-02"),
+		Until:   q.Until.Format("2006-01-02"),
+		Host:    req.URL.Query().Get("host"),
+		Class:   req.URL.Query().Get("class"),
+		Classes: AllClasses,
+	}
+	for _, r := range Reports() {
+		if !r.Available(ui.c) {
+			continue
+		}
+		res, err := RunReport(ui.c, ui.store, r, q)
+		if err != nil {
+			log.Error().Err(err).Str("report", r.Name).Msg("failed to run report")
+			http.Error(rw, "failed to run reports", http.StatusInternalServerError)
+			return
+		}
+		data.Reports = append(data.Reports, res)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(rw, data); err != nil {
+		log.Warn().Err(err).Msg("failed to render dashboard")
+	}
+}
+
+type dashboardData struct {
+	From, Until, Host, Class string
+	Classes                  []Class
+	Reports                  []*ReportResult
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Hindsight</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+section { display: inline-block; vertical-align: top; margin: 0 2em 2em 0; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.6em; text-align: left; }
+td.n { text-align: right; }
+tr:nth-child(even) { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>Hindsight</h1>
+<form method="get">
+<label>From <input type="date" name="from" value="{{.From}}"></label>
+<label>Until <input type="date" name="until" value="{{.Until}}"></label>
+<label>Host <input type="text" name="host" value="{{.Host}}"></label>
+<label>Class <select name="class">
+<option value="">pageviews (default)</option>
+{{range .Classes}}<option value="{{.}}"{{if eq (print .) $.Class}} selected{{end}}>{{.}}</option>{{end}}
+</select></label>
+<button type="submit">Update</button>
+</form>
+{{range .Reports}}
+<section>
+<h2>{{.Title}}</h2>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}<th>Visitors</th><th>Hits</th></tr>
+{{range .Rows}}<tr>{{range .Values}}<td>{{.}}</td>{{end}}<td class="n">{{.Visitors}}</td><td class="n">{{.Hits}}</td></tr>
+{{end}}
+</table>
+{{if .Suppressed}}<p><small>{{.Suppressed}} rows with too few visitors are not shown separately.</small></p>{{end}}
+{{if ge .Collisions 0.5}}<p><small>About {{printf "%.0f" .Collisions}} visitors are not counted, as they share a (truncated) visitor key with someone else.</small></p>{{end}}
+{{if gt (len .Schemes) 1}}<p><small>Visitor keys changed scheme in this period, so some visitors may be counted more than once.</small></p>{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
@@ -1,34 +1,77 @@
 package hindsight
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
-	"time"
+	"hash"
+	"math"
+	"net"
 )
 
-// the salt is deterministic with the same random data
-// in the configuration file.
-// NB config initialisation should write back the config file
-// with random randomness if non present, so we use the same
-// value between restarts
-func dailySalt(cfg *Config, t time.Time) []byte {
-	// day since unix epoch
-	u := t.Unix()
-	// u -= u % 86400 // truncate to a day
-	u /= 86400 // this should round down to a day without having to truncate
-	// we won't make the hash a KDF or anything hard to calculate, as we
-	// don't cache it yet
-	h := sha256.New()
-	fmt.Fprintf(h, "%s:%d", cfg.RandomSaltSeed, u)
-	return h.Sum(nil)
+// UniqueKey is the anonymous visitor key for the event, using the salt
+// for the scope (see Config.SaltScope) and period of the event. The scope
+// is used rather than the host, so visitors are the same across all the
+// hosts in a site group. The key is the raw bytes of the hash, truncated
+// to the configured number of bits.
+func (ic *IdentityConfig) UniqueKey(salt []byte, scope string, in *InboundEvent) string {
+	var key hash.Hash
+	if ic.Hash == HashHMAC {
+		key = hmac.New(sha256.New, salt)
+	} else {
+		key = sha256.New()
+	}
+	for _, comp := range ic.Components {
+		switch comp {
+		case IdentityHost:
+			fmt.Fprintf(key, "%s\n", scope)
+		case IdentityIP:
+			fmt.Fprintf(key, "%s\n", in.IP)
+		case IdentityIPNetwork:
+			ip := net.ParseIP(in.IP)
+			if ip != nil {
+				ip = TruncateIP(ip)
+			}
+			fmt.Fprintf(key, "%s\n", ip)
+		case IdentityUserAgent:
+			fmt.Fprintf(key, "%s\n", in.UserAgent)
+		case IdentityAcceptLanguage:
+			fmt.Fprintf(key, "%s\n", in.AcceptLanguage)
+		}
+	}
+	if ic.Hash != HashHMAC {
+		key.Write(salt)
+	}
+	return ic.truncate(key.Sum(nil))
 }
 
-func UniqueKey(cfg *Config, in *InboundEvent) string {
-	salt := dailySalt(cfg, in.Time)
-	key := sha256.New()
-	fmt.Fprintf(key, "%s\n%s\n%s\n", in.Host, in.IP, in.UserAgent)
-	key.Write(salt)
-	unique := key.Sum(nil)
-	return base64.RawURLEncoding.EncodeToString(unique)
+// keeps the first ic.Bits bits of the key, zeroing the rest of the last byte.
+func (ic *IdentityConfig) truncate(b []byte) string {
+	n := (ic.Bits + 7) / 8
+	if n > len(b) {
+		n = len(b)
+	}
+	b = append([]byte(nil), b[:n]...)
+	if rem := ic.Bits % 8; rem != 0 && n > 0 {
+		b[n-1] &= 0xff << (8 - rem)
+	}
+	return string(b)
+}
+
+// CollisionEstimate is the expected number of visitors wrongly counted as
+// the same as another, when counting this many visitors with keys of this
+// many bits.
+func CollisionEstimate(visitors int64, bits int) float64 {
+	if visitors < 2 || bits <= 0 {
+		return 0
+	}
+	v := float64(visitors)
+	n := math.Exp2(float64(bits))
+	if v/n < 1e-6 {
+		// the expected number of colliding pairs, when they are very unlikely
+		return v * (v - 1) / (2 * n)
+	}
+	// expected distinct keys is n(1-(1-1/n)^v)
+	distinct := -n * math.Expm1(v*math.Log1p(-1/n))
+	return v - distinct
 }